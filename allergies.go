@@ -0,0 +1,426 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/events"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+	"github.com/jesusrmoreno/parse"
+)
+
+func getAllergyProfilesFromParse(s *State, limit int) []models.AllergyProfile {
+	if limit > 1000 {
+		s.Log.Warn("Parse has a max return limit of 1000 objects.")
+		s.Log.Warn("Using 1000 as the limit")
+		limit = 1000
+	}
+	profiles := []models.AllergyProfile{}
+	skipValue := 0
+	for {
+		newProfiles := []models.AllergyProfile{}
+		status, errs := s.DB.Get(parse.Params{
+			Class: "AllergyProfile",
+			Limit: limit,
+			Skip:  skipValue,
+		}, &newProfiles)
+		if errs != nil {
+			s.Log.Fatal("Could not get allergy profiles, status:", status)
+		}
+		skipValue += limit
+		if len(newProfiles) == 0 {
+			break
+		}
+		profiles = append(profiles, newProfiles...)
+	}
+	return profiles
+}
+
+func getAvoidListsFromParse(s *State, limit int) []models.ParseAvoidList {
+	if limit > 1000 {
+		s.Log.Warn("Parse has a max return limit of 1000 objects.")
+		s.Log.Warn("Using 1000 as the limit")
+		limit = 1000
+	}
+	lists := []models.ParseAvoidList{}
+	skipValue := 0
+	for {
+		newLists := []models.ParseAvoidList{}
+		status, errs := s.DB.Get(parse.Params{
+			Class: "AvoidList",
+			Limit: limit,
+			Skip:  skipValue,
+		}, &newLists)
+		if errs != nil {
+			s.Log.Fatal("Could not get avoid lists, status:", status)
+		}
+		skipValue += limit
+		if len(newLists) == 0 {
+			break
+		}
+		lists = append(lists, newLists...)
+	}
+	return lists
+}
+
+func getAllergenAlertsFromParse(s *State, limit int) []models.ParseAllergenAlert {
+	if limit > 1000 {
+		s.Log.Warn("Parse has a max return limit of 1000 objects.")
+		s.Log.Warn("Using 1000 as the limit")
+		limit = 1000
+	}
+	alerts := []models.ParseAllergenAlert{}
+	skipValue := 0
+	for {
+		newAlerts := []models.ParseAllergenAlert{}
+		status, errs := s.DB.Get(parse.Params{
+			Class: "AllergenAlert",
+			Limit: limit,
+			Skip:  skipValue,
+		}, &newAlerts)
+		if errs != nil {
+			s.Log.Fatal("Could not get allergen alerts, status:", status)
+		}
+		skipValue += limit
+		if len(newAlerts) == 0 {
+			break
+		}
+		alerts = append(alerts, newAlerts...)
+	}
+	return alerts
+}
+
+// loadAllergies fills in the allergy profiles along with the avoid lists and
+// alerts we already made so they aren't made again.
+func loadAllergies(s *State) {
+	for _, p := range getAllergyProfilesFromParse(s, 1000) {
+		s.AllergyProfiles[p.User.ObjectID] = p
+	}
+	// Lists stored with an older UUID are kept by the one they have now so
+	// that they are still matched, see migrateAvoidListUUIDs.
+	for _, l := range getAvoidListsFromParse(s, 1000) {
+		l.UUID = l.GenerateUUID()
+		s.AvoidLists[l.UUID] = l
+	}
+	for _, a := range getAllergenAlertsFromParse(s, 1000) {
+		s.AllergenAlerts[a.UUID] = a
+	}
+}
+
+// addAvoidItems adds every recipe served at the venue to the avoid list of
+// each user that is allergic to it. lists is keyed by the avoid list's uuid.
+func addAvoidItems(s *State, lists map[string]*models.ParseAvoidList, info models.VenueInfo) {
+	if len(s.AllergyProfiles) == 0 {
+		return
+	}
+	meals, menus := mealMenuNames(info)
+	for _, recipe := range info.Recipes {
+		nutrients := *SetDietaryInfo(&recipe.Nutrients, recipe.Name)
+		for userID, profile := range s.AllergyProfiles {
+			conflicts := profile.Conflicts(nutrients)
+			if len(conflicts) == 0 {
+				continue
+			}
+			l := models.ParseAvoidList{
+				Class: "AvoidList",
+				Day:   info.Date.Day(),
+				Month: int(info.Date.Month()),
+				Year:  info.Date.Year(),
+				Site:  info.Site,
+				For: models.CreatedBy{
+					Kind:      "Pointer",
+					ClassName: "_User",
+					ObjectID:  userID,
+				},
+				OnDate: models.DateObject{
+					Type: "Date",
+					ISO:  info.Date,
+				},
+			}
+			l.UUID = l.GenerateUUID()
+			if lists[l.UUID] == nil {
+				lists[l.UUID] = &l
+			}
+			lists[l.UUID].Items = append(lists[l.UUID].Items, models.AvoidItem{
+				RecipeID:  recipe.ID,
+				Name:      models.RemoveMetaData(recipe.Name),
+				Venue:     info.Key,
+				MealName:  meals[recipe.MealID],
+				MenuName:  menus[recipe.MenuID],
+				Allergens: conflicts,
+			})
+		}
+	}
+}
+
+// sortAvoidItems sorts the items by venue, meal, menu and recipe so that lists
+// made from the same menus compare equal.
+func sortAvoidItems(items []models.AvoidItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		switch {
+		case a.Venue != b.Venue:
+			return a.Venue < b.Venue
+		case a.MealName != b.MealName:
+			return a.MealName < b.MealName
+		case a.MenuName != b.MenuName:
+			return a.MenuName < b.MenuName
+		}
+		return a.RecipeID < b.RecipeID
+	})
+}
+
+// saveAvoidLists brings the stored avoid lists of the site in line with the
+// ones made during the scrape. New lists are posted. On the scraped dates,
+// the days like reportDate on which every venue was scraped, stored lists
+// whose items changed are replaced and the ones that weren't made again, eg:
+// because the recipes are no longer served or the user's allergies changed,
+// are deleted. Stored lists of the dates where a venue failed are kept as
+// they are since the new ones could be missing its recipes.
+func saveAvoidLists(s *State, lists map[string]*models.ParseAvoidList, scraped map[string]bool) {
+	saved, updated, unchanged, deleted := 0, 0, 0, 0
+	for uuid, stored := range s.AvoidLists {
+		if _, ok := lists[uuid]; ok || models.SiteOf(stored.Site) != models.SiteOf(s.Site) {
+			continue
+		}
+		if !scraped[stored.Date().Format(reportDate)] {
+			continue
+		}
+		status, errs := s.DB.Delete(parse.Params{
+			Class:    "AvoidList",
+			ObjectID: stored.ObjectID(),
+		}, nil)
+		if errs != nil || status == 400 {
+			s.Log.WithField("status", status).Error(errors.Errorf("Unable to delete AvoidList with ID: %s", uuid))
+			continue
+		}
+		delete(s.AvoidLists, uuid)
+		deleted++
+	}
+	for uuid, l := range lists {
+		sortAvoidItems(l.Items)
+		if stored, ok := s.AvoidLists[uuid]; ok {
+			if !scraped[stored.Date().Format(reportDate)] {
+				continue
+			}
+			current := append([]models.AvoidItem{}, stored.Items...)
+			sortAvoidItems(current)
+			if reflect.DeepEqual(current, l.Items) {
+				unchanged++
+				continue
+			}
+			x := struct {
+				UUID  string             `json:"uuid"`
+				Items []models.AvoidItem `json:"items"`
+			}{
+				UUID:  uuid,
+				Items: l.Items,
+			}
+			_, status, errs := s.DB.Put(x, "AvoidList", stored.ObjectID())
+			if errs != nil || status == 400 {
+				s.Log.Error(status)
+				s.Log.Error(errors.Errorf("Unable to update AvoidList with ID: %s", uuid))
+				continue
+			}
+			stored.Items = l.Items
+			s.AvoidLists[uuid] = stored
+			updated++
+			continue
+		}
+		returnObj, status, errs := s.DB.Post(*l)
+		if errs != nil || status == 400 {
+			s.Log.Error(status)
+			s.Log.Error(errors.Errorf("Unable to post AvoidList with ID: %s", uuid))
+			continue
+		}
+		s.AvoidLists[uuid] = returnObj.(models.ParseAvoidList)
+		saved++
+	}
+	s.Log.WithFields(logrus.Fields{
+		"Saved":     saved,
+		"Updated":   updated,
+		"Unchanged": unchanged,
+		"Deleted":   deleted,
+	}).Info("Avoid Lists")
+}
+
+// versionAt returns the version of the recipe that was current at t, versions
+// can't be empty. If every version is newer than t the first one is returned,
+// a recipe's first version is only recorded when it first changes and is what
+// was stored before that so it is what was current at t.
+func versionAt(versions models.RecipeVersionSlice, t time.Time) models.ParseRecipeVersion {
+	current := versions[0]
+	for _, v := range versions {
+		// Versions saved during this run don't have a createdAt yet
+		if v.Created.IsZero() || v.Created.After(t) {
+			break
+		}
+		current = v
+	}
+	return current
+}
+
+// sameStrings is true if both sorted slices hold the same strings.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSubscribedAllergens returns an alert for every subscribed recipe whose
+// allergens are different now than when the subscription was last updated.
+// Alerts that were already raised for the recipe's current version are
+// skipped. The subscribed recipe ids are Dartmouth's so there is nothing to
+// check for the other sites, whose recipe ids could collide with them.
+func checkSubscribedAllergens(s *State) []models.ParseAllergenAlert {
+	alerts := []models.ParseAllergenAlert{}
+	if models.SiteOf(s.Site) != models.DefaultSite {
+		return alerts
+	}
+	for _, sub := range s.SubscriptionRecords {
+		for _, recipeID := range sub.Recipes {
+			versions := s.RecipeVersions[recipeID]
+			if len(versions) == 0 {
+				continue
+			}
+			then := versionAt(versions, sub.UpdatedAt)
+			now := versions[len(versions)-1]
+			before := models.Allergens(then.Nutrients)
+			after := models.Allergens(now.Nutrients)
+			if sameStrings(before, after) {
+				continue
+			}
+			a := models.ParseAllergenAlert{
+				Class: "AllergenAlert",
+				For: models.CreatedBy{
+					Kind:      "Pointer",
+					ClassName: "_User",
+					ObjectID:  sub.User.ObjectID,
+				},
+				RecipeID: recipeID,
+				Name:     now.Name,
+				Version:  now.Version,
+				Before:   before,
+				After:    after,
+			}
+			a.UUID = a.GenerateUUID()
+			if _, ok := s.AllergenAlerts[a.UUID]; !ok {
+				alerts = append(alerts, a)
+			}
+		}
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].RecipeID < alerts[j].RecipeID
+	})
+	return alerts
+}
+
+// saveAllergenAlerts posts the alerts and publishes each one as an
+// AllergenChanged event.
+func saveAllergenAlerts(s *State, alerts []models.ParseAllergenAlert) {
+	saved := 0
+	for _, a := range alerts {
+		returnObj, status, errs := s.DB.Post(a)
+		if errs != nil || status == 400 {
+			s.Log.Error(status)
+			s.Log.Error(errors.Errorf("Unable to post AllergenAlert with ID: %s", a.UUID))
+			continue
+		}
+		created := returnObj.(models.ParseAllergenAlert)
+		s.AllergenAlerts[a.UUID] = created
+		s.Events.Publish(events.AllergenChanged, created)
+		saved++
+	}
+	s.Log.WithFields(logrus.Fields{
+		"Saved": saved,
+	}).Info("Allergen Alerts")
+}
+
+// planAvoidListMigration works out which stored avoid lists need their uuid
+// changed to the one GenerateUUID gives now and which ones are duplicates that
+// should be deleted. When several lists end up with the same uuid the one that
+// already has it is kept, otherwise the first one is.
+func planAvoidListMigration(ls []models.ParseAvoidList) (update, remove []models.ParseAvoidList) {
+	sorted := make([]models.ParseAvoidList, len(ls))
+	copy(sorted, ls)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		iCurrent := sorted[i].UUID == sorted[i].GenerateUUID()
+		jCurrent := sorted[j].UUID == sorted[j].GenerateUUID()
+		return iCurrent && !jCurrent
+	})
+
+	kept := map[string]bool{}
+	for _, l := range sorted {
+		uuid := l.GenerateUUID()
+		if kept[uuid] {
+			remove = append(remove, l)
+			continue
+		}
+		kept[uuid] = true
+		if l.UUID != uuid {
+			l.UUID = uuid
+			update = append(update, l)
+		}
+	}
+	return update, remove
+}
+
+// migrateAvoidListUUIDs gives every stored avoid list the uuid that
+// GenerateUUID gives now and deletes the ones that turn out to be duplicates.
+// A list that was overwritten by another day's list because their old uuids
+// collided gets its own day's items back the next time that day is scraped.
+func migrateAvoidListUUIDs(c *cli.Context) {
+	s := newState()
+	ls := getAvoidListsFromParse(&s, 1000)
+	update, remove := planAvoidListMigration(ls)
+	s.Log.WithFields(logrus.Fields{
+		"Stored": len(ls),
+		"Update": len(update),
+		"Delete": len(remove),
+	}).Info("Avoid List UUID Migration")
+	if c.Bool("dry-run") {
+		return
+	}
+
+	updated, deleted := 0, 0
+	for _, l := range update {
+		x := struct {
+			UUID string `json:"uuid"`
+		}{
+			UUID: l.UUID,
+		}
+		_, status, errs := s.DB.Put(x, "AvoidList", l.ObjectID())
+		if errs != nil || status == 400 {
+			s.Log.Error(status)
+			s.Log.Error(errors.Errorf("Unable to update AvoidList with objectId: %s", l.ObjectID()))
+			continue
+		}
+		updated++
+	}
+	for _, l := range remove {
+		status, errs := s.DB.Delete(parse.Params{
+			Class:    "AvoidList",
+			ObjectID: l.ObjectID(),
+		}, nil)
+		if errs != nil || status == 400 {
+			s.Log.Error(status)
+			s.Log.Error(errors.Errorf("Unable to delete AvoidList with objectId: %s", l.ObjectID()))
+			continue
+		}
+		deleted++
+	}
+	s.Log.WithFields(logrus.Fields{
+		"Updated": updated,
+		"Deleted": deleted,
+	}).Info("Migrated Avoid Lists")
+}
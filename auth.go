@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/parse"
+)
+
+// sessionHeader carries the parse session token of the user making a request,
+// it is the token the apps get when the user logs in to parse.
+const sessionHeader = "X-Parse-Session-Token"
+
+// sessionCacheTTL is how long a checked session token is trusted before parse
+// is asked about it again.
+const sessionCacheTTL = 5 * time.Minute
+
+var (
+	errUnauthenticated = errors.Errorf("Authentication required, send the parse session token in %s", sessionHeader)
+	errForbidden       = errors.Errorf("Not allowed to access another user's data")
+	errReadOnly        = errors.Errorf("Mutations have to be sent in a POST request")
+)
+
+// authenticator finds the user making a request.
+type authenticator interface {
+	// User returns the objectId of the user, "" for anonymous requests.
+	User(r *http.Request) (string, error)
+}
+
+// checkedSession is a checked session token and the user it belongs to.
+type checkedSession struct {
+	user    string
+	checked time.Time
+}
+
+// sessionAuth finds the user making a request by asking parse who the session
+// token belongs to.
+type sessionAuth struct {
+	client *parse.Client
+	http   *http.Client
+
+	mu       sync.Mutex
+	sessions map[string]checkedSession
+}
+
+// newSessionAuth returns a sessionAuth checking tokens with the parse app of
+// the client.
+func newSessionAuth(client *parse.Client) *sessionAuth {
+	return &sessionAuth{
+		client:   client,
+		http:     &http.Client{Timeout: 10 * time.Second},
+		sessions: map[string]checkedSession{},
+	}
+}
+
+// User returns the objectId of the user the request's session token belongs
+// to, it is "" when the request has no token.
+func (a *sessionAuth) User(r *http.Request) (string, error) {
+	token := r.Header.Get(sessionHeader)
+	if token == "" {
+		return "", nil
+	}
+	a.mu.Lock()
+	cached, ok := a.sessions[token]
+	a.mu.Unlock()
+	if ok && time.Since(cached.checked) < sessionCacheTTL {
+		return cached.user, nil
+	}
+
+	req, err := http.NewRequest("GET", a.client.BaseURL+"/users/me", nil)
+	if err != nil {
+		return "", errors.Wrap(err, 1)
+	}
+	req.Header.Set("X-Parse-Application-Id", a.client.ApplicationID)
+	req.Header.Set("X-Parse-REST-API-Key", a.client.Key)
+	req.Header.Set(sessionHeader, token)
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, 1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("Invalid session token, parse answered %d", resp.StatusCode)
+	}
+	me := struct {
+		ObjectID string `json:"objectId"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&me); err != nil {
+		return "", errors.Wrap(err, 1)
+	}
+	if me.ObjectID == "" {
+		return "", errors.Errorf("Invalid session token")
+	}
+	a.mu.Lock()
+	a.sessions[token] = checkedSession{user: me.ObjectID, checked: time.Now()}
+	a.mu.Unlock()
+	return me.ObjectID, nil
+}
+
+// authKey is the type of the context keys of a request's caller.
+type authKey int
+
+const (
+	callerKey authKey = iota
+	mutableKey
+)
+
+// withCaller returns ctx carrying the user making the request and whether the
+// request may change anything.
+func withCaller(ctx context.Context, user string, mutable bool) context.Context {
+	ctx = context.WithValue(ctx, callerKey, user)
+	return context.WithValue(ctx, mutableKey, mutable)
+}
+
+// callerAllowed returns an error unless the request was made by the user.
+func callerAllowed(ctx context.Context, user string) error {
+	caller, _ := ctx.Value(callerKey).(string)
+	if caller == "" {
+		return errUnauthenticated
+	}
+	if caller != user {
+		return errForbidden
+	}
+	return nil
+}
+
+// mutationAllowed returns an error unless the request may change user, only
+// the user can change their own data and only in a POST request.
+func mutationAllowed(ctx context.Context, user string) error {
+	if mutable, _ := ctx.Value(mutableKey).(bool); !mutable {
+		return errReadOnly
+	}
+	return callerAllowed(ctx, user)
+}
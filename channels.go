@@ -0,0 +1,286 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/metrics"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+	"github.com/jesusrmoreno/nutrition-scraper/notify"
+)
+
+// newEmail configures the SMTP notifier from the command line flags.
+func newEmail(c *cli.Context) *notify.Email {
+	text := notify.DefaultTemplate
+	if path := c.String("email-template"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		text = string(b)
+	}
+	t, err := notify.ParseTemplate(text)
+	if err != nil {
+		log.Fatal(err)
+	}
+	from, err := mail.ParseAddress(c.String("smtp-from"))
+	if err != nil {
+		log.Fatal(errors.Errorf("Invalid --smtp-from %q: %s", c.String("smtp-from"), err))
+	}
+	return &notify.Email{
+		Host:     c.String("smtp-host"),
+		Port:     c.Int("smtp-port"),
+		Username: c.String("smtp-user"),
+		Password: c.String("smtp-password"),
+		From:     from,
+		Template: t,
+	}
+}
+
+// newChannels configures the channels notifications can be delivered over
+// from the command line flags. Email is only available with --smtp-host and
+// the chat channels only with --channels.
+func newChannels(c *cli.Context) map[string]notify.Channel {
+	channels := map[string]notify.Channel{}
+	if c.String("smtp-host") != "" {
+		channels[models.ChannelEmail] = newEmail(c)
+	}
+	if c.Bool("channels") {
+		var w io.Writer = ioutil.Discard
+		if path := c.String("channel-log"); path != "" {
+			file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				log.Fatal(err)
+			}
+			w = file
+		}
+		h := notify.NewHTTP(c.Int("channel-retries"), w)
+		for _, ch := range []notify.Channel{notify.Webhook{HTTP: h}, notify.Slack{HTTP: h}, notify.Discord{HTTP: h}} {
+			channels[ch.Type()] = ch
+		}
+	}
+	return channels
+}
+
+// deliveryFlags configure the channels for both the scrape and the deliver
+// command.
+var deliveryFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "smtp-host",
+		Usage: "If present the created notifications are emailed to the users through this SMTP server.",
+	},
+	cli.IntFlag{
+		Name:  "smtp-port",
+		Value: 587,
+		Usage: "Port of the SMTP server.",
+	},
+	cli.StringFlag{
+		Name:  "smtp-user",
+		Usage: "Username for the SMTP server, leave empty if it doesn't need authentication.",
+	},
+	cli.StringFlag{
+		Name:   "smtp-password",
+		Usage:  "Password for the SMTP server.",
+		EnvVar: "SMTP_PASSWORD",
+	},
+	cli.StringFlag{
+		Name:  "smtp-from",
+		Value: "Dartmouth Nutrition <no-reply@localhost>",
+		Usage: "From address of the emails.",
+	},
+	cli.StringFlag{
+		Name:  "email-template",
+		Usage: "Path to a template defining the subject and body of the emails.",
+	},
+	cli.BoolFlag{
+		Name:  "channels",
+		Usage: "Deliver notifications to the webhook, Slack and Discord urls in the users' preferences.",
+	},
+	cli.IntFlag{
+		Name:  "channel-retries",
+		Value: 3,
+		Usage: "How many times a failed webhook, Slack or Discord delivery is retried.",
+	},
+	cli.StringFlag{
+		Name:  "channel-log",
+		Value: "notification-deliveries.log",
+		Usage: "File every webhook, Slack and Discord delivery attempt is appended to.",
+	},
+}
+
+// delivery is a pending notification or digest waiting to be delivered.
+type delivery struct {
+	class         string
+	objectID      string
+	uuid          string
+	notifications []models.ParseNotification
+}
+
+// pendingDeliveries returns the pending notifications and digests that are
+// due at now grouped by user. Digests are due from their SendAt and nothing
+// is due once the day it is for is over.
+func pendingDeliveries(s *State, now time.Time) map[string][]delivery {
+	byUser := map[string][]delivery{}
+	notificationsMu.RLock()
+	for _, n := range s.Notifications {
+		if !n.Pending || n.Dismissed || n.Expired(now) {
+			continue
+		}
+		byUser[n.For.ObjectID] = append(byUser[n.For.ObjectID], delivery{
+			class:         "Notification",
+			objectID:      n.ObjectID(),
+			uuid:          n.UUID,
+			notifications: []models.ParseNotification{n},
+		})
+	}
+	notificationsMu.RUnlock()
+	for _, d := range s.Digests {
+		if !d.Pending || d.SendAt.ISO.After(now) || !now.Before(d.ExpiresAt()) {
+			continue
+		}
+		byUser[d.For.ObjectID] = append(byUser[d.For.ObjectID], delivery{
+			class:         "Digest",
+			objectID:      d.ObjectID(),
+			uuid:          d.UUID,
+			notifications: d.Notifications(),
+		})
+	}
+	return byUser
+}
+
+// markDelivered records that the delivery is no longer pending.
+func markDelivered(s *State, d delivery, now time.Time) error {
+	at := &models.DateObject{Type: "Date", ISO: now}
+	x := struct {
+		Pending     bool               `json:"pending"`
+		DeliveredAt *models.DateObject `json:"deliveredAt"`
+	}{
+		Pending:     false,
+		DeliveredAt: at,
+	}
+	_, status, errs := s.DB.Put(x, d.class, d.objectID)
+	if errs != nil || status == 400 {
+		return errors.Errorf("Unable to mark %s %s as delivered, status: %d", d.class, d.uuid, status)
+	}
+	switch d.class {
+	case "Notification":
+		notificationsMu.Lock()
+		n := s.Notifications[d.uuid]
+		n.Pending, n.DeliveredAt = false, at
+		s.Notifications[d.uuid] = n
+		notificationsMu.Unlock()
+	case "Digest":
+		digest := s.Digests[d.uuid]
+		digest.Pending, digest.DeliveredAt = false, at
+		s.Digests[d.uuid] = digest
+	}
+	return nil
+}
+
+// dispatchNotifications sends every user that wants to be notified at now a
+// single message listing all of their pending notifications and digests, over
+// each of the channels they picked. Users in their quiet hours or outside of
+// their delivery window are left pending for a later run. Channels that
+// aren't configured are skipped and a delivery stays pending unless at least
+// one of the user's channels took it.
+func dispatchNotifications(s *State, channels map[string]notify.Channel, now time.Time) {
+	if len(channels) == 0 {
+		return
+	}
+	byUser := pendingDeliveries(s, now)
+	if len(byUser) == 0 {
+		return
+	}
+	if len(s.Users) == 0 {
+		for _, u := range getUsersFromParse(s, 1000) {
+			s.Users[u.ID] = u
+		}
+	}
+
+	sent, failed := map[string]int{}, map[string]int{}
+	deferred := 0
+	for userID, deliveries := range byUser {
+		u, ok := s.Users[userID]
+		if !ok {
+			s.Log.WithFields(logrus.Fields{
+				"user": userID,
+			}).Warn("Unknown User")
+			failed["unknown"]++
+			continue
+		}
+		ok, err := u.CanNotify(now)
+		if err != nil {
+			s.Log.WithFields(logrus.Fields{
+				"user": userID,
+			}).Warn(err)
+		}
+		if !ok {
+			deferred++
+			continue
+		}
+
+		ns := []models.ParseNotification{}
+		for _, d := range deliveries {
+			ns = append(ns, d.notifications...)
+		}
+		m := notify.NewMessage(u, ns, s.Venues)
+		delivered := false
+		for _, p := range u.Preferences() {
+			ch, ok := channels[p.Type]
+			if !ok {
+				continue
+			}
+			if err := ch.Send(m, p); err != nil {
+				s.Log.WithFields(logrus.Fields{
+					"user":    userID,
+					"channel": p.Type,
+				}).Error(err)
+				failed[p.Type]++
+				metrics.Errors.WithLabelValues(metrics.ErrorDelivery).Inc()
+				continue
+			}
+			sent[p.Type]++
+			delivered = true
+		}
+		if !delivered {
+			continue
+		}
+		for _, d := range deliveries {
+			if err := markDelivered(s, d, now); err != nil {
+				s.Log.Error(err)
+			}
+		}
+	}
+	s.Log.WithFields(logrus.Fields{
+		"Sent":     sent,
+		"Failed":   failed,
+		"Deferred": deferred,
+	}).Info("Delivered Notifications")
+}
+
+// deliverCommand delivers the pending notifications and digests of the --site
+// sites, it is meant to be run regularly so that users get what was held back
+// by their quiet hours once they are over.
+func deliverCommand(c *cli.Context) {
+	sites, err := sitesFromContext(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	channels := newChannels(c)
+	for _, st := range sites {
+		s := newState()
+		useSite(&s, st)
+		for _, n := range getNotificationsFromParse(&s, 1000, siteWhere(&s)) {
+			s.Notifications[n.UUID] = n
+		}
+		loadDigests(&s)
+		loadVenueNames(&s, st)
+		dispatchNotifications(&s, channels, time.Now())
+	}
+}
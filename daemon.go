@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/metrics"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+	"github.com/jesusrmoreno/nutrition-scraper/schedule"
+)
+
+// The tasks a daemon job can run.
+const (
+	taskScrape  = "scrape"
+	taskPrune   = "prune"
+	taskDeliver = "deliver"
+)
+
+// daemonJob is a job in the daemon configuration file.
+type daemonJob struct {
+	Name string `json:"name"`
+	// Schedule is a cron expression in models.Location eg: "0 2 * * *"
+	Schedule string `json:"schedule"`
+	Task     string `json:"task"`
+	// Offset is the first day scraped relative to today and Days is how many
+	// days are scraped, they are only used by scrapes.
+	Offset int `json:"offset"`
+	Days   int `json:"days"`
+	// Jitter overrides the daemon's --jitter for this job eg: "30s"
+	Jitter string `json:"jitter"`
+	// Sites overrides the daemon's --site for this job eg: "dartmouth,state"
+	Sites string `json:"sites"`
+}
+
+// daemonConfig is the daemon configuration file eg:
+//
+//	{
+//	  "jobs": [
+//	    {"name": "nightly", "schedule": "0 2 * * *", "task": "scrape", "days": 7},
+//	    {"name": "prune", "schedule": "0 4 * * 0", "task": "prune"}
+//	  ]
+//	}
+type daemonConfig struct {
+	Jobs []daemonJob `json:"jobs"`
+}
+
+// defaultDaemonJobs are run when the daemon isn't given a configuration file.
+var defaultDaemonJobs = []daemonJob{
+	{Name: "nightly-scrape", Schedule: "0 2 * * *", Task: taskScrape, Days: 7},
+	{Name: "hourly-today", Schedule: "30 * * * *", Task: taskScrape, Days: 1},
+	{Name: "deliver", Schedule: "*/15 * * * *", Task: taskDeliver},
+	{Name: "weekly-prune", Schedule: "0 4 * * 0", Task: taskPrune},
+}
+
+// loadDaemonConfig reads the configuration file, the default jobs are used if
+// path is empty.
+func loadDaemonConfig(path string) (daemonConfig, error) {
+	config := daemonConfig{Jobs: defaultDaemonJobs}
+	if path == "" {
+		return config, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return config, errors.Wrap(err, 1)
+	}
+	defer file.Close()
+	config.Jobs = nil
+	if err := json.NewDecoder(file).Decode(&config); err != nil {
+		return config, errors.Wrap(err, 1)
+	}
+	return config, nil
+}
+
+// newDaemonState returns a State initialized for the site for a single run,
+// every run gets its own so that it sees what the previous runs stored. The
+// returned func has to be called once the run is over.
+func newDaemonState(c *cli.Context, st site) (*State, func()) {
+	s := newState()
+	done := func() {}
+	if config := c.String("webhooks"); config != "" {
+		done = setupWebhooks(&s, config)
+	}
+	useSite(&s, st)
+	InitParse(&s)
+	return &s, done
+}
+
+// jobSites returns the sites the job runs for, its "sites" or else --site.
+func jobSites(c *cli.Context, j daemonJob) ([]site, error) {
+	registry, err := loadSites(c.String("sites"))
+	if err != nil {
+		return nil, err
+	}
+	keys := c.String("site")
+	if j.Sites != "" {
+		keys = j.Sites
+	}
+	sites, err := pickSites(registry, keys)
+	if err != nil {
+		return nil, errors.Errorf("Job %s: %s", j.Name, err)
+	}
+	return sites, nil
+}
+
+// daemonTask returns the function running the job's task. Every task runs for
+// each of the job's sites in turn and a site failing doesn't stop the others.
+func daemonTask(c *cli.Context, j daemonJob) (func() error, error) {
+	sites, err := jobSites(c, j)
+	if err != nil {
+		return nil, err
+	}
+	switch j.Task {
+	case taskScrape:
+		opts, err := scrapeOptionsFromContext(c)
+		if err != nil {
+			return nil, err
+		}
+		if j.Days > 0 {
+			opts.Days = j.Days
+		}
+		return func() error {
+			var failed error
+			for _, st := range sites {
+				s, done := newDaemonState(c, st)
+				opts.Site = st
+				opts.Start = models.Day(time.Now()).AddDate(0, 0, j.Offset)
+				if err := runScrape(s, opts); err != nil {
+					s.Log.Error(err)
+					failed = err
+				}
+				done()
+			}
+			return failed
+		}, nil
+	case taskPrune:
+		retention := retentionFromContext(c)
+		return func() error {
+			var failed error
+			for _, st := range sites {
+				s := newState()
+				useSite(&s, st)
+				ns := getNotificationsFromParse(&s, 1000, siteWhere(&s))
+				ds := getDigestsFromParse(&s, 1000, siteWhere(&s))
+				for _, report := range []pruneReport{
+					pruneNotifications(&s, ns, retention, time.Now(), false),
+					pruneDigests(&s, ds, retention, time.Now(), false),
+				} {
+					report.Log(s.Log)
+					if report.Failed > 0 {
+						failed = errors.Errorf("Unable to delete %d %ss of %s", report.Failed, report.Class, st.Key)
+					}
+				}
+			}
+			return failed
+		}, nil
+	case taskDeliver:
+		channels := newChannels(c)
+		return func() error {
+			for _, st := range sites {
+				s, done := newDaemonState(c, st)
+				loadVenueNames(s, st)
+				dispatchNotifications(s, channels, time.Now())
+				done()
+			}
+			return nil
+		}, nil
+	}
+	return nil, errors.Errorf("Job %s has unknown task %q", j.Name, j.Task)
+}
+
+// daemonJobs turns the configured jobs into scheduler jobs. All of the scrapes
+// share a lock so that they never run at the same time.
+func daemonJobs(c *cli.Context, config daemonConfig) ([]schedule.Job, error) {
+	jobs := []schedule.Job{}
+	for _, j := range config.Jobs {
+		cron, err := schedule.Parse(j.Schedule, models.Location)
+		if err != nil {
+			return nil, err
+		}
+		jitter := c.Duration("jitter")
+		if j.Jitter != "" {
+			if jitter, err = time.ParseDuration(j.Jitter); err != nil {
+				return nil, errors.Errorf("Job %s has a bad jitter: %s", j.Name, err)
+			}
+		}
+		run, err := daemonTask(c, j)
+		if err != nil {
+			return nil, err
+		}
+		lock := ""
+		if j.Task == taskScrape {
+			lock = taskScrape
+		}
+		jobs = append(jobs, schedule.Job{
+			Name:   j.Name,
+			Cron:   cron,
+			Jitter: jitter,
+			Lock:   lock,
+			Run:    logRun(j.Name, run),
+		})
+	}
+	return jobs, nil
+}
+
+// logRun logs when the run starts and how it ended.
+func logRun(name string, run func() error) func() error {
+	return func() error {
+		start := time.Now()
+		log.WithFields(logrus.Fields{
+			"job": name,
+		}).Info("Start Job")
+		err := run()
+		fields := logrus.Fields{
+			"job":      name,
+			"duration": time.Since(start).String(),
+		}
+		if err != nil {
+			log.WithFields(fields).Error(err)
+			return err
+		}
+		log.WithFields(fields).Info("Finish Job")
+		return nil
+	}
+}
+
+// daemon runs the scheduled jobs until it is interrupted. The status of the
+// jobs is served as json at /status on --status-addr along with the metrics
+// at /metrics and the probes at /healthz and /readyz.
+func daemon(c *cli.Context) {
+	config, err := loadDaemonConfig(c.String("config"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	jobs, err := daemonJobs(c, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	loadLastScrape(c.String("report"))
+	scheduler := schedule.New(jobs)
+	scheduler.Start()
+	for _, j := range jobs {
+		log.WithFields(logrus.Fields{
+			"job":      j.Name,
+			"schedule": j.Cron.Text,
+			"next":     j.Cron.Next(time.Now()).Format(time.RFC3339),
+		}).Info("Scheduled Job")
+	}
+
+	if addr := c.String("status-addr"); addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, scheduler.Status())
+		})
+		mux.Handle("/metrics", metrics.Handler())
+		s := newState()
+		newHealthChecker(c, &s).Mount(mux)
+		go func() {
+			log.WithFields(logrus.Fields{
+				"addr": addr,
+			}).Info("Serving Status")
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				log.Error(err)
+			}
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	log.Info("Stopping Daemon, waiting for running jobs")
+	scheduler.Stop()
+}
+
+// daemonStatus prints the status served by a running daemon as a table.
+func daemonStatus(c *cli.Context) {
+	res, err := http.Get("http://" + c.String("status-addr") + "/status")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer res.Body.Close()
+	statuses := []schedule.Status{}
+	if err := json.NewDecoder(res.Body).Decode(&statuses); err != nil {
+		log.Fatal(err)
+	}
+	printStatus(os.Stdout, statuses)
+}
+
+// printStatus writes the statuses as a table.
+func printStatus(w io.Writer, statuses []schedule.Status) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tSCHEDULE\tSTATE\tLAST RUN\tNEXT RUN\tRUNS\tFAILED\tSKIPPED\tLAST ERROR")
+	when := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.In(models.Location).Format("Jan 2 15:04:05")
+	}
+	for _, st := range statuses {
+		state := "idle"
+		if st.Running {
+			state = "running"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n",
+			st.Name, st.Schedule, state, when(st.LastRun), when(st.NextRun),
+			st.Runs, st.Failures, st.Skipped, st.LastError)
+	}
+	tw.Flush()
+}
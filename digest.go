@@ -0,0 +1,226 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/events"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+	"github.com/jesusrmoreno/parse"
+)
+
+// The windows a digest can cover, tomorrow only digests the notifications for
+// the day after the scrape while week digests everything we scraped.
+const (
+	digestTomorrow = "tomorrow"
+	digestWeek     = "week"
+)
+
+// getDigestsFromParse returns the stored digests matching the where query, an
+// empty one matches every digest.
+func getDigestsFromParse(s *State, limit int, where string) []models.ParseDigest {
+	if limit > 1000 {
+		s.Log.Warn("Parse has a max return limit of 1000 objects.")
+		s.Log.Warn("Using 1000 as the limit")
+		limit = 1000
+	}
+	digests := []models.ParseDigest{}
+	skipValue := 0
+	for {
+		newDigests := []models.ParseDigest{}
+		status, errs := s.DB.Get(parse.Params{
+			Class: "Digest",
+			Limit: limit,
+			Skip:  skipValue,
+			Where: where,
+		}, &newDigests)
+		if errs != nil {
+			s.Log.Fatal("Could not get digests, status:", status)
+		}
+		skipValue += limit
+		if len(newDigests) == 0 {
+			break
+		}
+		digests = append(digests, newDigests...)
+	}
+	return digests
+}
+
+// loadDigests keeps the stored digests of the state's site in the state by the
+// UUID GenerateUUID gives now, so that the ones stored with an older UUID are
+// still matched. Their uuid is fixed the next time they are updated.
+func loadDigests(s *State) {
+	for _, d := range getDigestsFromParse(s, 1000, siteWhere(s)) {
+		d.UUID = d.GenerateUUID()
+		s.Digests[d.UUID] = d
+	}
+}
+
+// inDigestWindow is true if a notification for day belongs in a digest made
+// on today, both are compared as days in loc.
+func inDigestWindow(window string, day, today time.Time, loc *time.Location) bool {
+	day, today = models.DayIn(day, loc), models.DayIn(today, loc)
+	switch window {
+	case digestTomorrow:
+		return day.Equal(today.AddDate(0, 0, 1))
+	case digestWeek:
+		return !day.Before(today) && day.Before(today.AddDate(0, 0, 7))
+	}
+	return false
+}
+
+// nextSendTime returns the first time after now that the clock in loc shows
+// clock, which looks like 07:30.
+func nextSendTime(clock string, now time.Time, loc *time.Location) (time.Time, error) {
+	now = now.In(loc)
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, errors.Errorf("Unable to parse digest time %s make sure it looks like HH:MM", clock)
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(),
+		t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, nil
+}
+
+// digestSendAt is when the digest for day, which is midnight in its site's
+// timezone, should be sent. It is sent at sendAt unless its day is over by
+// then, eg: today's digest when the scrape ran after the digest time, which is
+// sent right away instead.
+func digestSendAt(day, sendAt, now time.Time) time.Time {
+	if !sendAt.Before(day.AddDate(0, 0, 1)) {
+		return now
+	}
+	return sendAt
+}
+
+// createDigests groups the notifications in the window into one digest per
+// user, date, venue and meal. The window is made of days in the timezone of
+// the notifications' site.
+func createDigests(ns []models.ParseNotification, window string, sendAt, now time.Time) []models.ParseDigest {
+	byUUID := map[string]*models.ParseDigest{}
+	uuids := []string{}
+	for _, n := range ns {
+		if !inDigestWindow(window, n.Date(), now, models.LocationOf(n.Site)) {
+			continue
+		}
+		d := models.ParseDigest{
+			Class:    "Digest",
+			Day:      n.Day,
+			Month:    n.Month,
+			Year:     n.Year,
+			For:      n.For,
+			OnDate:   n.OnDate,
+			SendAt:   models.DateObject{Type: "Date", ISO: digestSendAt(n.Date(), sendAt, now)},
+			Pending:  true,
+			MealName: n.MealName,
+			Venue:    n.Venue,
+			Site:     n.Site,
+		}
+		d.UUID = d.GenerateUUID()
+		if byUUID[d.UUID] == nil {
+			byUUID[d.UUID] = &d
+			uuids = append(uuids, d.UUID)
+		}
+		digest := byUUID[d.UUID]
+		// A recipe can show up on more than one menu of the same meal
+		duplicate := false
+		for _, item := range digest.Items {
+			if item.RecipeID == n.RecipeID && item.MenuName == n.MenuName {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			digest.Items = append(digest.Items, models.DigestItem{
+				RecipeID: n.RecipeID,
+				Name:     n.Name,
+				MenuName: n.MenuName,
+			})
+		}
+	}
+
+	digests := []models.ParseDigest{}
+	for _, uuid := range uuids {
+		digests = append(digests, *byUUID[uuid])
+	}
+	sort.SliceStable(digests, func(i, j int) bool {
+		return digests[i].Date().Before(digests[j].Date())
+	})
+	return digests
+}
+
+// sameItems is true if both digests list the same recipes.
+func sameItems(a, b []models.DigestItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[models.DigestItem]bool{}
+	for _, item := range a {
+		seen[item] = true
+	}
+	for _, item := range b {
+		if !seen[item] {
+			return false
+		}
+	}
+	return true
+}
+
+// saveDigests posts the digests we don't have yet and updates the items of the
+// stored digests that gained or lost recipes. It returns the digests that were
+// created or updated.
+func saveDigests(s *State, ds []models.ParseDigest) []models.ParseDigest {
+	saved := []models.ParseDigest{}
+	duplicates := 0
+	for _, d := range ds {
+		stored, ok := s.Digests[d.UUID]
+		if ok && sameItems(stored.Items, d.Items) {
+			duplicates++
+			continue
+		}
+		if ok {
+			x := struct {
+				UUID    string              `json:"uuid"`
+				Items   []models.DigestItem `json:"items"`
+				SendAt  models.DateObject   `json:"sendAt"`
+				Seen    bool                `json:"seen"`
+				Pending bool                `json:"pending"`
+			}{
+				UUID:    d.UUID,
+				Items:   d.Items,
+				SendAt:  d.SendAt,
+				Pending: true,
+			}
+			_, status, errs := s.DB.Put(x, "Digest", stored.ObjectID())
+			if errs != nil || status == 400 {
+				s.Log.Error(status)
+				s.Log.Error(errors.Errorf("Unable to update Digest with ID: %s", d.UUID))
+				continue
+			}
+			stored.Items, stored.SendAt, stored.Seen, stored.Pending = d.Items, d.SendAt, false, true
+			s.Digests[d.UUID] = stored
+			saved = append(saved, stored)
+			continue
+		}
+
+		returnObj, status, errs := s.DB.Post(d)
+		if errs != nil || status == 400 {
+			s.Log.Error(status)
+			s.Log.Error(errors.Errorf("Unable to post Digest with ID: %s", d.UUID))
+			continue
+		}
+		created := returnObj.(models.ParseDigest)
+		s.Digests[d.UUID] = created
+		s.Events.Publish(events.DigestCreated, created)
+		saved = append(saved, created)
+	}
+	s.Log.WithFields(logrus.Fields{
+		"Saved":     len(saved),
+		"Duplicate": duplicates,
+	}).Info("Digests")
+	return saved
+}
@@ -0,0 +1,77 @@
+// Package events lets the scraper announce what it changed in the store
+// without knowing who is listening.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// The types of events published during a scrape.
+const (
+	// OfferingCreated is published with the models.ParseOffering when a new
+	// offering is saved.
+	OfferingCreated = "offering.created"
+	// OfferingUpdated is published with a models.OfferingChange when recipes
+	// are added to or removed from a stored offering.
+	OfferingUpdated = "offering.updated"
+	// RecipeCreated is published with the models.ParseRecipe when a recipe we
+	// haven't seen before is saved.
+	RecipeCreated = "recipe.created"
+	// RecipeUpdated is published with the new models.ParseRecipeVersion when a
+	// stored recipe changes.
+	RecipeUpdated = "recipe.updated"
+	// AllergenChanged is published with the models.ParseAllergenAlert when the
+	// allergens of a recipe somebody is subscribed to change.
+	AllergenChanged = "allergen.changed"
+	// DigestCreated is published with the models.ParseDigest when a digest is
+	// saved for a user.
+	DigestCreated = "digest.created"
+	// NotificationCreated is published with the models.ParseNotification when
+	// a notification is saved for a user.
+	NotificationCreated = "notification.created"
+)
+
+// Event is something that happened during a scrape.
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Handler is called with every published event.
+type Handler func(Event)
+
+// Bus passes published events to every subscribed handler.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+// NewBus returns a Bus without any handlers.
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe adds a handler that will be called for every event published
+// after it was added.
+func (b *Bus) Subscribe(h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, h)
+}
+
+// Publish calls every handler with the event. Handlers are called in the
+// order they subscribed and publish waits for them to return.
+func (b *Bus) Publish(eventType string, data interface{}) {
+	e := Event{
+		Type: eventType,
+		Time: time.Now(),
+		Data: data,
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, h := range b.handlers {
+		h(e)
+	}
+}
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// venue is the GraphQL source for a venue, parse doesn't store them so they
+// are put together from the offerings.
+type venue struct {
+	Key  string
+	Name string
+}
+
+// user is the GraphQL source for a user, we only know about the users through
+// their subscriptions and notifications.
+type user struct {
+	ID string
+}
+
+// nutrientField describes one of the nutrients exposed on the Nutrients type.
+type nutrientField struct {
+	name  string
+	value func(n *models.NutrientInfoResponse) string
+}
+
+// nutrientFields are the nutrients that make up a nutrition label, the daily
+// value percentages are exposed with a DV suffix.
+var nutrientFields = []nutrientField{
+	{"servingSize", func(n *models.NutrientInfoResponse) string { return n.Result.ServingSizeText }},
+	{"calories", func(n *models.NutrientInfoResponse) string { return n.Result.Calories }},
+	{"caloriesFromFat", func(n *models.NutrientInfoResponse) string { return n.Result.Calfat }},
+	{"fat", func(n *models.NutrientInfoResponse) string { return n.Result.Fat }},
+	{"fatDV", func(n *models.NutrientInfoResponse) string { return n.Result.FatP }},
+	{"saturatedFat", func(n *models.NutrientInfoResponse) string { return n.Result.Sfa }},
+	{"saturatedFatDV", func(n *models.NutrientInfoResponse) string { return n.Result.SfaP }},
+	{"transFat", func(n *models.NutrientInfoResponse) string { return n.Result.Fatrans }},
+	{"cholesterol", func(n *models.NutrientInfoResponse) string { return n.Result.Cholestrol }},
+	{"cholesterolDV", func(n *models.NutrientInfoResponse) string { return n.Result.CholestrolP }},
+	{"sodium", func(n *models.NutrientInfoResponse) string { return n.Result.Sodium }},
+	{"sodiumDV", func(n *models.NutrientInfoResponse) string { return n.Result.SodiumP }},
+	{"carbs", func(n *models.NutrientInfoResponse) string { return n.Result.Carbs }},
+	{"carbsDV", func(n *models.NutrientInfoResponse) string { return n.Result.CarbsP }},
+	{"fiber", func(n *models.NutrientInfoResponse) string { return n.Result.Fiberdtry }},
+	{"fiberDV", func(n *models.NutrientInfoResponse) string { return n.Result.FiberdtryP }},
+	{"sugars", func(n *models.NutrientInfoResponse) string { return n.Result.Sugars }},
+	{"protein", func(n *models.NutrientInfoResponse) string { return n.Result.Protein }},
+	{"proteinDV", func(n *models.NutrientInfoResponse) string { return n.Result.ProteinP }},
+	{"vitaminADV", func(n *models.NutrientInfoResponse) string { return n.Result.VitaIuP }},
+	{"vitaminCDV", func(n *models.NutrientInfoResponse) string { return n.Result.VitcP }},
+	{"calciumDV", func(n *models.NutrientInfoResponse) string { return n.Result.CalciumP }},
+	{"ironDV", func(n *models.NutrientInfoResponse) string { return n.Result.IronP }},
+}
+
+// dietaryFields are the flags set by SetDietaryInfo.
+var dietaryFields = map[string]func(n *models.NutrientInfoResponse) bool{
+	"vegetarian": func(n *models.NutrientInfoResponse) bool { return n.Result.Vegetarian },
+	"vegan":      func(n *models.NutrientInfoResponse) bool { return n.Result.Vegan },
+	"glutenFree": func(n *models.NutrientInfoResponse) bool { return n.Result.Gluten },
+	"local":      func(n *models.NutrientInfoResponse) bool { return n.Result.Local },
+	"kosher":     func(n *models.NutrientInfoResponse) bool { return n.Result.Kosher },
+	"halal":      func(n *models.NutrientInfoResponse) bool { return n.Result.Halal },
+	"eggs":       func(n *models.NutrientInfoResponse) bool { return n.Result.Eggs },
+	"fish":       func(n *models.NutrientInfoResponse) bool { return n.Result.Fish },
+	"dairy":      func(n *models.NutrientInfoResponse) bool { return n.Result.Dairy },
+	"treeNuts":   func(n *models.NutrientInfoResponse) bool { return n.Result.TreeNuts },
+	"peanuts":    func(n *models.NutrientInfoResponse) bool { return n.Result.Peanuts },
+	"pork":       func(n *models.NutrientInfoResponse) bool { return n.Result.Pork },
+	"soy":        func(n *models.NutrientInfoResponse) bool { return n.Result.Soy },
+	"shellFish":  func(n *models.NutrientInfoResponse) bool { return n.Result.ShellFish },
+	"wheat":      func(n *models.NutrientInfoResponse) bool { return n.Result.Wheat },
+}
+
+// dietaryArgs lets a recipe list be filtered by any of the dietary flags,
+// eg: recipes(vegan: true)
+func dietaryArgs() graphql.FieldConfigArgument {
+	args := graphql.FieldConfigArgument{}
+	for name := range dietaryFields {
+		args[name] = &graphql.ArgumentConfig{Type: graphql.Boolean}
+	}
+	return args
+}
+
+// filterRecipes keeps the recipes whose dietary flags match every flag given
+// in args.
+func filterRecipes(rs []models.ParseRecipe, args map[string]interface{}) []models.ParseRecipe {
+	filtered := []models.ParseRecipe{}
+	for _, r := range rs {
+		keep := true
+		for name, flag := range dietaryFields {
+			want, ok := args[name].(bool)
+			if ok && flag(&r.Nutrients) != want {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// offeringDate is the day an offering is served on.
+func offeringDate(o models.ParseOffering) time.Time {
+	return models.Date(o.Year, time.Month(o.Month), o.Day)
+}
+
+// findOfferings returns the offerings matching the non empty arguments sorted
+// by date, venue, meal and menu.
+func findOfferings(s *State, args map[string]interface{}) ([]models.ParseOffering, error) {
+	venueKey, _ := args["venue"].(string)
+	meal, _ := args["meal"].(string)
+	menu, _ := args["menu"].(string)
+	var date time.Time
+	if d, ok := args["date"].(string); ok && d != "" {
+		parsed, err := models.ParseDate(dateTemplate, d)
+		if err != nil {
+			return nil, err
+		}
+		date = parsed
+	}
+
+	offerings := []models.ParseOffering{}
+	for _, o := range s.Offerings {
+		if venueKey != "" && o.Venue != venueKey {
+			continue
+		}
+		if meal != "" && !strings.EqualFold(o.MealName, meal) {
+			continue
+		}
+		if menu != "" && !strings.EqualFold(o.MenuName, menu) {
+			continue
+		}
+		if !date.IsZero() && !offeringDate(o).Equal(date) {
+			continue
+		}
+		offerings = append(offerings, o)
+	}
+	sort.Slice(offerings, func(i, j int) bool {
+		a, b := offerings[i], offerings[j]
+		if !offeringDate(a).Equal(offeringDate(b)) {
+			return offeringDate(a).Before(offeringDate(b))
+		}
+		if a.Venue != b.Venue {
+			return a.Venue < b.Venue
+		}
+		if a.MealName != b.MealName {
+			return a.MealName < b.MealName
+		}
+		return a.MenuName < b.MenuName
+	})
+	return offerings, nil
+}
+
+// findRecipes looks up the stored recipes for the Dartmouth ids, ids we don't
+// know about are skipped.
+func findRecipes(s *State, ids []int) []models.ParseRecipe {
+	recipes := []models.ParseRecipe{}
+	for _, id := range ids {
+		if r, ok := s.Recipes[id]; ok {
+			recipes = append(recipes, r)
+		}
+	}
+	return recipes
+}
+
+// newSchema builds the GraphQL schema over the data loaded into s. The graph
+// goes venues -> offerings -> recipes -> nutrients and users -> subscriptions
+// and notifications.
+func newSchema(s *State) (graphql.Schema, error) {
+	offeringArgs := graphql.FieldConfigArgument{
+		"venue": &graphql.ArgumentConfig{Type: graphql.String},
+		"date": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "MM/dd/YY",
+		},
+		"meal": &graphql.ArgumentConfig{Type: graphql.String},
+		"menu": &graphql.ArgumentConfig{Type: graphql.String},
+	}
+
+	nutrients := graphql.Fields{}
+	for _, f := range nutrientFields {
+		value := f.value
+		nutrients[f.name] = &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				n := p.Source.(models.NutrientInfoResponse)
+				return value(&n), nil
+			},
+		}
+	}
+	for name, flag := range dietaryFields {
+		flag := flag
+		nutrients[name] = &graphql.Field{
+			Type: graphql.Boolean,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				n := p.Source.(models.NutrientInfoResponse)
+				return flag(&n), nil
+			},
+		}
+	}
+	nutrientsType := graphql.NewObject(graphql.ObjectConfig{
+		Name:   "Nutrients",
+		Fields: nutrients,
+	})
+
+	// The object types reference each other so they have to be declared before
+	// their fields are.
+	var venueType, offeringType, recipeType, userType, notificationType *graphql.Object
+
+	venueType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Venue",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"key": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(venue).Key, nil
+					},
+				},
+				"name": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(venue).Name, nil
+					},
+				},
+				"offerings": &graphql.Field{
+					Type: graphql.NewList(offeringType),
+					Args: graphql.FieldConfigArgument{
+						"date": offeringArgs["date"],
+						"meal": offeringArgs["meal"],
+						"menu": offeringArgs["menu"],
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						args := map[string]interface{}{"venue": p.Source.(venue).Key}
+						for k, v := range p.Args {
+							args[k] = v
+						}
+						return findOfferings(s, args)
+					},
+				},
+			}
+		}),
+	})
+
+	offeringType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Offering",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"uuid": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseOffering).UUID, nil
+					},
+				},
+				"venue": &graphql.Field{
+					Type: venueType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						key := p.Source.(models.ParseOffering).Venue
+						return venueFor(s, key), nil
+					},
+				},
+				"date": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return offeringDate(p.Source.(models.ParseOffering)).Format(dateTemplate), nil
+					},
+				},
+				"mealName": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseOffering).MealName, nil
+					},
+				},
+				"menuName": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseOffering).MenuName, nil
+					},
+				},
+				"recipes": &graphql.Field{
+					Type: graphql.NewList(recipeType),
+					Args: dietaryArgs(),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						o := p.Source.(models.ParseOffering)
+						return filterRecipes(findRecipes(s, o.RecipeIDs), p.Args), nil
+					},
+				},
+			}
+		}),
+	})
+
+	recipeType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Recipe",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseRecipe).ObjectID(), nil
+					},
+				},
+				"dartmouthId": &graphql.Field{
+					Type: graphql.Int,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseRecipe).DartmouthID, nil
+					},
+				},
+				"name": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseRecipe).Name, nil
+					},
+				},
+				"category": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseRecipe).Category, nil
+					},
+				},
+				"nutrients": &graphql.Field{
+					Type: nutrientsType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseRecipe).Nutrients, nil
+					},
+				},
+				"offerings": &graphql.Field{
+					Type: graphql.NewList(offeringType),
+					Args: offeringArgs,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						id := p.Source.(models.ParseRecipe).DartmouthID
+						all, err := findOfferings(s, p.Args)
+						if err != nil {
+							return nil, err
+						}
+						offerings := []models.ParseOffering{}
+						for _, o := range all {
+							for _, recipeID := range o.RecipeIDs {
+								if recipeID == id {
+									offerings = append(offerings, o)
+									break
+								}
+							}
+						}
+						return offerings, nil
+					},
+				},
+			}
+		}),
+	})
+
+	notificationType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Notification",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"uuid": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseNotification).UUID, nil
+					},
+				},
+				"recipe": &graphql.Field{
+					Type: recipeType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						r, ok := s.Recipes[p.Source.(models.ParseNotification).RecipeID]
+						if !ok {
+							return nil, nil
+						}
+						return r, nil
+					},
+				},
+				"date": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseNotification).Date().Format(dateTemplate), nil
+					},
+				},
+				"venue": &graphql.Field{
+					Type: venueType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return venueFor(s, p.Source.(models.ParseNotification).Venue), nil
+					},
+				},
+				"mealName": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseNotification).MealName, nil
+					},
+				},
+				"menuName": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseNotification).MenuName, nil
+					},
+				},
+				"seen": &graphql.Field{
+					Type: graphql.Boolean,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseNotification).Seen, nil
+					},
+				},
+				"state": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(models.ParseNotification).State(), nil
+					},
+				},
+			}
+		}),
+	})
+
+	userType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(user).ID, nil
+					},
+				},
+				"subscriptions": &graphql.Field{
+					Type: graphql.NewList(recipeType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						id := p.Source.(user).ID
+						ids := []int{}
+						for recipeID, users := range s.Subscriptions {
+							for _, u := range users {
+								if u == id {
+									ids = append(ids, recipeID)
+									break
+								}
+							}
+						}
+						sort.Ints(ids)
+						return findRecipes(s, ids), nil
+					},
+				},
+				"rules": &graphql.Field{
+					Type: graphql.NewList(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						id := p.Source.(user).ID
+						texts := []string{}
+						for _, sub := range s.RuleSubscriptions {
+							if sub.User == id {
+								texts = append(texts, sub.Rule.Text)
+							}
+						}
+						return texts, nil
+					},
+				},
+				"notifications": &graphql.Field{
+					Type: graphql.NewList(notificationType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						id := p.Source.(user).ID
+						ns := []models.ParseNotification{}
+						notificationsMu.RLock()
+						for _, n := range s.Notifications {
+							if n.For.ObjectID == id {
+								ns = append(ns, n)
+							}
+						}
+						notificationsMu.RUnlock()
+						sort.Slice(ns, func(i, j int) bool {
+							return ns[i].Date().Before(ns[j].Date())
+						})
+						return ns, nil
+					},
+				},
+			}
+		}),
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"venues": &graphql.Field{
+				Type: graphql.NewList(venueType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					keys := map[string]bool{}
+					for key := range s.Venues {
+						keys[key] = true
+					}
+					for _, o := range s.Offerings {
+						keys[o.Venue] = true
+					}
+					venues := []venue{}
+					for key := range keys {
+						venues = append(venues, venueFor(s, key))
+					}
+					sort.Slice(venues, func(i, j int) bool {
+						return venues[i].Key < venues[j].Key
+					})
+					return venues, nil
+				},
+			},
+			"offerings": &graphql.Field{
+				Type: graphql.NewList(offeringType),
+				Args: offeringArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return findOfferings(s, p.Args)
+				},
+			},
+			"recipe": &graphql.Field{
+				Type: recipeType,
+				Args: graphql.FieldConfigArgument{
+					"dartmouthId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					r, ok := s.Recipes[p.Args["dartmouthId"].(int)]
+					if !ok {
+						return nil, nil
+					}
+					return r, nil
+				},
+			},
+			// Users can only see their own subscriptions and notifications.
+			"user": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.ID),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id := p.Args["id"].(string)
+					if err := callerAllowed(p.Context, id); err != nil {
+						return nil, err
+					}
+					return user{ID: id}, nil
+				},
+			},
+		},
+	})
+
+	// markField is a mutation moving the notification with the uuid to the
+	// state, only the user the notification is for can change it.
+	markField := func(state string) *graphql.Field {
+		return &graphql.Field{
+			Type: notificationType,
+			Args: graphql.FieldConfigArgument{
+				"uuid": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.ID),
+				},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				n, err := findNotification(s, p.Args["uuid"].(string))
+				if err != nil {
+					return nil, err
+				}
+				if err := mutationAllowed(p.Context, n.For.ObjectID); err != nil {
+					return nil, err
+				}
+				return markNotification(s, n, state, time.Now())
+			},
+		}
+	}
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"markNotificationSeen": markField(models.NotificationSeen),
+			"dismissNotification":  markField(models.NotificationDismissed),
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    queryType,
+		Mutation: mutationType,
+	})
+}
+
+// venueFor returns the venue for the key using the display name if we have
+// one.
+func venueFor(s *State, key string) venue {
+	name := s.Venues[key]
+	if name == "" {
+		name = key
+	}
+	return venue{Key: key, Name: name}
+}
+
+// graphqlRequest is the body of a GraphQL POST request.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlHandler executes queries sent either as a POST body or as the query
+// parameter of a GET request eg:
+//
+//	{ offerings(venue: "DDS", date: "02/27/16", meal: "Dinner") {
+//	    menuName recipes(vegan: true) { name nutrients { calories protein } }
+//	} }
+//
+// The caller is found with auth, users' own data needs it. Mutations are only
+// run for POST requests so that they can't be made from a link.
+func graphqlHandler(schema graphql.Schema, auth authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, err := auth.User(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		req := graphqlRequest{}
+		switch r.Method {
+		case "GET":
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+			if v := r.URL.Query().Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		case "POST":
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			OperationName:  req.OperationName,
+			VariableValues: req.Variables,
+			Context:        withCaller(r.Context(), caller, r.Method == "POST"),
+		})
+		writeJSON(w, http.StatusOK, result)
+	})
+}
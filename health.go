@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/lib"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+	"github.com/jesusrmoreno/parse"
+)
+
+// healthFlags configure /healthz and /readyz for both the server and the
+// daemon.
+var healthFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "probe-venue",
+		Value: "DDS",
+		Usage: "The venue /readyz asks Dartmouth for a SID for.",
+	},
+	cli.DurationFlag{
+		Name:  "probe-cache",
+		Value: 30 * time.Second,
+		Usage: "How long the result of /readyz is reused before Dartmouth is asked again.",
+	},
+	cli.DurationFlag{
+		Name:  "max-scrape-age",
+		Usage: "If set /healthz fails when the last successful scrape is older than this.",
+	},
+}
+
+// check is the result of a single health or readiness check.
+type check struct {
+	Name    string  `json:"name"`
+	OK      bool    `json:"ok"`
+	Error   string  `json:"error,omitempty"`
+	Seconds float64 `json:"seconds"`
+}
+
+// runCheck times fn and turns what it returns into a check.
+func runCheck(name string, fn func() error) check {
+	start := time.Now()
+	err := fn()
+	c := check{Name: name, OK: err == nil, Seconds: time.Since(start).Seconds()}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// lastScrape is the report of the last successful scrape, either in this process or read from the report file at startup.
+var lastScrape struct {
+	sync.Mutex
+	run *models.ParseScrapeRun
+}
+
+// scrapeSucceeded is true if the run finished, scraped at least one venue and
+// none of the venues failed. Venues that only show up because notifications
+// for them were deleted weren't scraped.
+func scrapeSucceeded(r *models.ParseScrapeRun) bool {
+	if r.Error != "" {
+		return false
+	}
+	scraped := 0
+	for _, v := range r.Venues {
+		if v.Error != "" || v.Failed {
+			return false
+		}
+		if v.Seconds > 0 {
+			scraped++
+		}
+	}
+	return scraped > 0
+}
+
+// recordScrape remembers the report if the run succeeded.
+func recordScrape(r *models.ParseScrapeRun) {
+	if !scrapeSucceeded(r) {
+		return
+	}
+	lastScrape.Lock()
+	defer lastScrape.Unlock()
+	lastScrape.run = r
+}
+
+// loadLastScrape reads the report a scraper wrote to file so that a server
+// that doesn't scrape itself can report on it. A missing file is fine.
+func loadLastScrape(file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	r := models.ParseScrapeRun{}
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		log.WithField("file", file).Warn("Unable to read the last run report")
+		return
+	}
+	recordScrape(&r)
+}
+
+// health is the body of /healthz.
+type health struct {
+	OK     bool    `json:"ok"`
+	Checks []check `json:"checks"`
+	// LastScrape is when the last successful scrape finished and
+	// LastScrapeRun its run id, they are empty if we don't know of any.
+	LastScrape    *time.Time `json:"lastScrape,omitempty"`
+	LastScrapeRun string     `json:"lastScrapeRun,omitempty"`
+}
+
+// readiness is the body of /readyz.
+type readiness struct {
+	OK      bool      `json:"ok"`
+	Checks  []check   `json:"checks"`
+	Checked time.Time `json:"checked"`
+}
+
+// healthChecker serves /healthz and /readyz.
+type healthChecker struct {
+	s            *State
+	probeVenue   string
+	probeCache   time.Duration
+	maxScrapeAge time.Duration
+
+	mu    sync.Mutex
+	ready *readiness
+}
+
+// newHealthChecker reads the health flags.
+func newHealthChecker(c *cli.Context, s *State) *healthChecker {
+	return &healthChecker{
+		s:            s,
+		probeVenue:   c.String("probe-venue"),
+		probeCache:   c.Duration("probe-cache"),
+		maxScrapeAge: c.Duration("max-scrape-age"),
+	}
+}
+
+// Mount adds /healthz and /readyz to the mux.
+func (h *healthChecker) Mount(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", h.serveHealth)
+	mux.HandleFunc("/readyz", h.serveReady)
+}
+
+// checkStore makes sure parse answers a query.
+func (h *healthChecker) checkStore() error {
+	recipes := []models.ParseRecipe{}
+	status, errs := h.s.DB.Get(parse.Params{
+		Class: "Recipe",
+		Limit: 1,
+	}, &recipes)
+	if errs != nil || status >= 400 {
+		return errors.Errorf("Parse answered with status %d", status)
+	}
+	return nil
+}
+
+// Health checks the store and how long ago the last successful scrape was.
+func (h *healthChecker) Health(now time.Time) health {
+	res := health{Checks: []check{runCheck("store", h.checkStore)}}
+	lastScrape.Lock()
+	run := lastScrape.run
+	lastScrape.Unlock()
+	if run != nil {
+		finished := run.Finished.ISO
+		res.LastScrape = &finished
+		res.LastScrapeRun = run.RunID
+	}
+	if h.maxScrapeAge > 0 {
+		res.Checks = append(res.Checks, runCheck("scrape", func() error {
+			if run == nil {
+				return errors.Errorf("No successful scrape yet")
+			}
+			if age := now.Sub(run.Finished.ISO); age > h.maxScrapeAge {
+				return errors.Errorf("The last successful scrape was %s ago", age.Truncate(time.Second))
+			}
+			return nil
+		}))
+	}
+	res.OK = allOK(res.Checks)
+	return res
+}
+
+// checkSIDs makes sure Dartmouth still lists the venues with names and
+// includes the probe venue.
+func (h *healthChecker) checkSIDs() error {
+	sids, err := lib.AvailableSIDS()
+	if err != nil {
+		return err
+	}
+	for key, name := range sids {
+		if key == "" || name == "" {
+			return errors.Errorf("Venue %q has no key or name", key+name)
+		}
+	}
+	if _, ok := sids[h.probeVenue]; !ok {
+		return errors.Errorf("Venue %s isn't listed anymore", h.probeVenue)
+	}
+	return nil
+}
+
+// checkSID makes sure Dartmouth gives us a SID for the probe venue.
+func (h *healthChecker) checkSID() error {
+	_, err := lib.SID(h.probeVenue)
+	return err
+}
+
+// Ready probes Dartmouth. The result is reused for probeCache so that frequent
+// probes don't turn into a stream of requests to Dartmouth.
+func (h *healthChecker) Ready(now time.Time) readiness {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ready != nil && now.Sub(h.ready.Checked) < h.probeCache {
+		return *h.ready
+	}
+	res := readiness{
+		Checks: []check{
+			runCheck("sids", h.checkSIDs),
+			runCheck("sid", h.checkSID),
+		},
+		Checked: now,
+	}
+	res.OK = allOK(res.Checks)
+	h.ready = &res
+	return res
+}
+
+// allOK is true if every check passed.
+func allOK(checks []check) bool {
+	for _, c := range checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// statusFor is 200 when ok and 503 otherwise.
+func statusFor(ok bool) int {
+	if ok {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
+
+func (h *healthChecker) serveHealth(w http.ResponseWriter, r *http.Request) {
+	res := h.Health(time.Now())
+	writeJSON(w, statusFor(res.OK), res)
+}
+
+func (h *healthChecker) serveReady(w http.ResponseWriter, r *http.Request) {
+	res := h.Ready(time.Now())
+	writeJSON(w, statusFor(res.OK), res)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/lib"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// importFlags configure the import command.
+var importFlags = append([]cli.Flag{
+	cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Only validate the files and show what would be imported.",
+	},
+}, siteFlags...)
+
+// venueFiles are the files a directory given to import is searched for.
+const venueFiles = "output_*.json"
+
+// importResult is what importing a file did. Skipped says why the file wasn't
+// imported, the stored recipes and offerings were already in parse and were
+// left as they are.
+type importResult struct {
+	File            string
+	Venue           string
+	Date            string
+	Skipped         string
+	Recipes         int
+	NewRecipes      int
+	StoredRecipes   int
+	NewOfferings    int
+	StoredOfferings int
+}
+
+// importPaths expands the arguments into the files to import. Arguments are
+// glob patterns or directories, which are searched for output_*.json files.
+// Every argument has to match at least one file.
+func importPaths(args []string) ([]string, error) {
+	paths := []string{}
+	seen := map[string]bool{}
+	for _, arg := range args {
+		pattern := arg
+		if stat, err := os.Stat(arg); err == nil && stat.IsDir() {
+			pattern = filepath.Join(arg, venueFiles)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+		if len(matches) == 0 {
+			return nil, errors.Errorf("No files match %s", arg)
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				paths = append(paths, m)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// validateVenueInfo checks that the file's VenueInfo can be saved for the
+// state's site. Every recipe has to be in one of the meals and menus listed,
+// otherwise it wouldn't be in any offering.
+func validateVenueInfo(s *State, info models.VenueInfo) error {
+	if models.SiteOf(info.Site) != models.SiteOf(s.Site) {
+		return errors.Errorf("Written for site %s not %s", models.SiteOf(info.Site), models.SiteOf(s.Site))
+	}
+	if info.Date.IsZero() {
+		return errors.Errorf("Venue %s has no date", info.Key)
+	}
+	offered := map[[2]int]bool{}
+	for _, mm := range info.MealsList {
+		for _, menu := range mm.Menus {
+			offered[[2]int{mm.Meal.ID, menu.ID}] = true
+		}
+	}
+	for _, r := range info.Recipes {
+		if r.ID == 0 {
+			return errors.Errorf("Recipe %q has no id", r.Name)
+		}
+		if !offered[[2]int{r.MealID, r.MenuID}] {
+			return errors.Errorf("Recipe %d is in meal %d and menu %d which aren't listed", r.ID, r.MealID, r.MenuID)
+		}
+	}
+	return nil
+}
+
+// importFiles saves the recipes and offerings of the VenueInfo files to
+// parse, s should already be initialized with InitParse. Only what isn't
+// stored yet is created, the files are usually older than what is stored so
+// the stored recipes and offerings are never changed. A venue and date in
+// several files is only imported from the first one. Files that don't
+// validate are skipped. With dryRun nothing is saved.
+func importFiles(s *State, paths []string, dryRun bool) []importResult {
+	results := []importResult{}
+	imported := map[string]string{}
+	for i, path := range paths {
+		fileLog := s.Log.WithFields(logrus.Fields{
+			"file":     path,
+			"progress": fmt.Sprintf("%d/%d", i+1, len(paths)),
+		})
+		result := importResult{File: path}
+		info, err := lib.ReadVenueFile(path)
+		if err == nil {
+			err = validateVenueInfo(s, info)
+		}
+		if err != nil {
+			fileLog.Warn(err)
+			result.Skipped = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Venue = info.Key
+		result.Date = info.Date.Format(reportDate)
+		key := info.Key + " " + result.Date
+		if first, ok := imported[key]; ok {
+			fileLog.WithField("first", first).Warn("Duplicate Venue File")
+			result.Skipped = "duplicate of " + first
+			results = append(results, result)
+			continue
+		}
+		imported[key] = path
+
+		unique := uniqueRecipes(info.Recipes)
+		result.Recipes = len(unique)
+		for _, r := range unique {
+			if s.Recipes[r.ID].DartmouthID == r.ID {
+				result.StoredRecipes++
+			} else {
+				result.NewRecipes++
+			}
+		}
+		for _, mm := range info.MealsList {
+			for _, menu := range mm.Menus {
+				if offeringExists(s, info.Key, menu.Name, mm.Meal.Name, info.Date) {
+					result.StoredOfferings++
+				} else {
+					result.NewOfferings++
+				}
+			}
+		}
+		if info.Venue != "" {
+			s.Venues[info.Key] = info.Venue
+		}
+		if !dryRun {
+			saved := createInParse(s, info)
+			result.NewRecipes = saved.NewRecipes
+			result.StoredRecipes = saved.DuplicateRecipes
+		}
+		fileLog.WithFields(logrus.Fields{
+			"venue":     result.Venue,
+			"date":      result.Date,
+			"recipes":   result.Recipes,
+			"offerings": result.NewOfferings + result.StoredOfferings,
+		}).Info("Imported")
+		results = append(results, result)
+	}
+	return results
+}
+
+// printImport writes the results as a table with a row for every file
+// followed by the totals.
+func printImport(w io.Writer, results []importResult, dryRun bool) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tVENUE\tDATE\tRECIPES\tNEW RECIPES\tSTORED RECIPES\tNEW OFFERINGS\tSTORED OFFERINGS\tSKIPPED")
+	total := importResult{File: "total"}
+	skipped := 0
+	for _, r := range results {
+		if r.Skipped != "" {
+			skipped++
+		}
+		total.Recipes += r.Recipes
+		total.NewRecipes += r.NewRecipes
+		total.StoredRecipes += r.StoredRecipes
+		total.NewOfferings += r.NewOfferings
+		total.StoredOfferings += r.StoredOfferings
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			r.File, r.Venue, r.Date, r.Recipes, r.NewRecipes, r.StoredRecipes,
+			r.NewOfferings, r.StoredOfferings, r.Skipped)
+	}
+	total.Skipped = fmt.Sprintf("%d of %d", skipped, len(results))
+	fmt.Fprintf(tw, "%s\t\t\t%d\t%d\t%d\t%d\t%d\t%s\n",
+		total.File, total.Recipes, total.NewRecipes, total.StoredRecipes,
+		total.NewOfferings, total.StoredOfferings, total.Skipped)
+	tw.Flush()
+	if dryRun {
+		fmt.Fprintln(w, "\nDry run, nothing was saved.")
+	}
+}
+
+// importCommand imports VenueInfo files written by --write-files.
+func importCommand(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		log.Fatal("import needs at least one file, glob or directory")
+	}
+	paths, err := importPaths(c.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	sites, err := sitesFromContext(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(sites) != 1 {
+		log.Fatal("import needs a single --site")
+	}
+	s := newState()
+	useSite(&s, sites[0])
+	InitParse(&s)
+	dryRun := c.Bool("dry-run")
+	printImport(os.Stdout, importFiles(&s, paths, dryRun), dryRun)
+}
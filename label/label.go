@@ -0,0 +1,278 @@
+// Package label renders FDA style Nutrition Facts panels from the nutrient
+// information we get back from the get_nutrient_label_items request.
+package label
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// Formats the label can be rendered as.
+const (
+	Text = "text"
+	HTML = "html"
+	SVG  = "svg"
+)
+
+// Missing is shown for values the response doesn't have, a missing value isn't
+// the same as 0.
+const Missing = "—"
+
+// ErrNoNutrients is returned for recipes whose nutrients weren't scraped
+// successfully, a panel for them would only show missing values.
+var ErrNoNutrients = errors.Errorf("The nutrients weren't scraped successfully")
+
+// Line is a single row of the panel eg: Total Fat 12g 18%
+type Line struct {
+	Name   string
+	Amount string
+	DV     string
+	// Sub rows are indented under the row above them, eg: Saturated Fat is
+	// indented under Total Fat
+	Sub bool
+}
+
+// Facts holds everything shown on the panel.
+type Facts struct {
+	Title           string
+	ServingSize     string
+	Calories        string
+	CaloriesFromFat string
+	Lines           []Line
+	Vitamins        []Line
+}
+
+// isNumber is true if the value doesn't already carry a unit.
+func isNumber(v string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	return err == nil
+}
+
+// amount adds the unit to a value if the value doesn't already have one.
+// Missing values are shown as Missing.
+func amount(v, unit string) string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return Missing
+	}
+	if isNumber(v) {
+		return v + unit
+	}
+	return v
+}
+
+// dv formats a daily value percentage, percentages we don't have are left
+// blank like they are on the printed labels.
+func dv(v string) string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return ""
+	}
+	if isNumber(v) {
+		return v + "%"
+	}
+	return v
+}
+
+// FromNutrients builds the panel for a recipe. name is used as the title if
+// the response doesn't have one. ErrNoNutrients is returned if the nutrients
+// weren't scraped successfully.
+func FromNutrients(name string, n models.NutrientInfoResponse) (Facts, error) {
+	r := n.Result
+	if !r.Success {
+		return Facts{}, ErrNoNutrients
+	}
+	title := models.RemoveMetaData(r.Title)
+	if strings.TrimSpace(title) == "" {
+		title = models.RemoveMetaData(name)
+	}
+	return Facts{
+		Title:           strings.TrimSpace(title),
+		ServingSize:     r.ServingSizeText,
+		Calories:        amount(r.Calories, ""),
+		CaloriesFromFat: amount(r.Calfat, ""),
+		Lines: []Line{
+			{Name: "Total Fat", Amount: amount(r.Fat, "g"), DV: dv(r.FatP)},
+			{Name: "Saturated Fat", Amount: amount(r.Sfa, "g"), DV: dv(r.SfaP), Sub: true},
+			{Name: "Trans Fat", Amount: amount(r.Fatrans, "g"), DV: dv(r.FatransP), Sub: true},
+			{Name: "Cholesterol", Amount: amount(r.Cholestrol, "mg"), DV: dv(r.CholestrolP)},
+			{Name: "Sodium", Amount: amount(r.Sodium, "mg"), DV: dv(r.SodiumP)},
+			{Name: "Total Carbohydrate", Amount: amount(r.Carbs, "g"), DV: dv(r.CarbsP)},
+			{Name: "Dietary Fiber", Amount: amount(r.Fiberdtry, "g"), DV: dv(r.FiberdtryP), Sub: true},
+			{Name: "Sugars", Amount: amount(r.Sugars, "g"), DV: dv(r.SugarsP), Sub: true},
+			{Name: "Protein", Amount: amount(r.Protein, "g"), DV: dv(r.ProteinP)},
+		},
+		Vitamins: []Line{
+			{Name: "Vitamin A", DV: dv(r.VitaIuP)},
+			{Name: "Vitamin C", DV: dv(r.VitcP)},
+			{Name: "Calcium", DV: dv(r.CalciumP)},
+			{Name: "Iron", DV: dv(r.IronP)},
+		},
+	}, nil
+}
+
+// ContentType returns the mime type for the format.
+func ContentType(format string) string {
+	switch format {
+	case HTML:
+		return "text/html; charset=utf-8"
+	case SVG:
+		return "image/svg+xml"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Render renders the panel in the given format.
+func Render(format string, f Facts) (string, error) {
+	switch format {
+	case Text, "":
+		return RenderText(f), nil
+	case HTML:
+		return execute(htmlTemplate, f)
+	case SVG:
+		return execute(svgTemplate, svgFacts(f))
+	}
+	return "", errors.Errorf("Unknown label format: %s", format)
+}
+
+// RenderText renders the panel as plain text for the terminal.
+func RenderText(f Facts) string {
+	const width = 40
+	b := &bytes.Buffer{}
+	thick := strings.Repeat("=", width)
+	thin := strings.Repeat("-", width)
+	row := func(left, right string) {
+		if right == "" {
+			fmt.Fprintln(b, left)
+			return
+		}
+		pad := width - len(left) - len(right)
+		if pad < 1 {
+			pad = 1
+		}
+		fmt.Fprintf(b, "%s%s%s\n", left, strings.Repeat(" ", pad), right)
+	}
+
+	fmt.Fprintln(b, "Nutrition Facts")
+	fmt.Fprintln(b, f.Title)
+	fmt.Fprintf(b, "Serving Size %s\n", f.ServingSize)
+	fmt.Fprintln(b, thick)
+	fmt.Fprintln(b, "Amount Per Serving")
+	row("Calories "+f.Calories, "Calories from Fat "+f.CaloriesFromFat)
+	fmt.Fprintln(b, thin)
+	row("", "% Daily Value*")
+	for _, l := range f.Lines {
+		name := l.Name
+		if l.Sub {
+			name = "  " + name
+		}
+		row(name+" "+l.Amount, l.DV)
+	}
+	fmt.Fprintln(b, thick)
+	for _, l := range f.Vitamins {
+		row(l.Name, l.DV)
+	}
+	fmt.Fprintln(b, thin)
+	fmt.Fprintln(b, "* Percent Daily Values are based on a")
+	fmt.Fprintln(b, "2,000 calorie diet.")
+	return b.String()
+}
+
+func execute(t *template.Template, data interface{}) (string, error) {
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, data); err != nil {
+		return "", errors.Wrap(err, 1)
+	}
+	return b.String(), nil
+}
+
+var htmlTemplate = template.Must(template.New("html").Parse(`<section class="nutrition-facts" style="border:1px solid #000;padding:4px;width:260px;font-family:Helvetica,Arial,sans-serif;font-size:12px">
+  <h1 style="font-size:26px;font-weight:900;margin:0">Nutrition Facts</h1>
+  <p style="margin:0">{{.Title}}</p>
+  <p style="margin:0;border-bottom:8px solid #000">Serving Size {{.ServingSize}}</p>
+  <p style="margin:0;font-weight:bold">Amount Per Serving</p>
+  <p style="margin:0;border-bottom:4px solid #000"><b>Calories</b> {{.Calories}} <span style="float:right">Calories from Fat {{.CaloriesFromFat}}</span></p>
+  <p style="margin:0;text-align:right;font-weight:bold;border-bottom:1px solid #000">% Daily Value*</p>
+  <table style="width:100%;border-collapse:collapse">
+    {{range .Lines}}<tr style="border-bottom:1px solid #000">
+      <td{{if .Sub}} style="padding-left:12px"{{end}}>{{if .Sub}}{{.Name}}{{else}}<b>{{.Name}}</b>{{end}} {{.Amount}}</td>
+      <td style="text-align:right;font-weight:bold">{{.DV}}</td>
+    </tr>
+    {{end}}
+  </table>
+  <table style="width:100%;border-collapse:collapse;border-top:8px solid #000">
+    {{range .Vitamins}}<tr style="border-bottom:1px solid #000">
+      <td>{{.Name}}</td>
+      <td style="text-align:right">{{.DV}}</td>
+    </tr>
+    {{end}}
+  </table>
+  <p style="margin:0;font-size:10px">* Percent Daily Values are based on a 2,000 calorie diet.</p>
+</section>
+`))
+
+// svgLine is a Line placed on the SVG panel.
+type svgLine struct {
+	Line
+	Y int
+	X int
+}
+
+// svgPanel holds the Facts along with the coordinates of every row.
+type svgPanel struct {
+	Facts
+	Rows     []svgLine
+	Vits     []svgLine
+	VitsTop  int
+	Footnote int
+	Height   int
+}
+
+// svgFacts lays out the rows of the panel, every row is 18 pixels tall.
+func svgFacts(f Facts) svgPanel {
+	p := svgPanel{Facts: f}
+	y := 152
+	for _, l := range f.Lines {
+		x := 8
+		if l.Sub {
+			x = 20
+		}
+		p.Rows = append(p.Rows, svgLine{Line: l, X: x, Y: y})
+		y += 18
+	}
+	p.VitsTop = y - 12
+	y += 8
+	for _, l := range f.Vitamins {
+		p.Vits = append(p.Vits, svgLine{Line: l, X: 8, Y: y})
+		y += 18
+	}
+	p.Footnote = y + 4
+	p.Height = y + 16
+	return p
+}
+
+var svgTemplate = template.Must(template.New("svg").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="280" height="{{.Height}}" font-family="Helvetica, Arial, sans-serif" font-size="12">
+  <rect x="1" y="1" width="278" height="{{.Height}}" fill="#fff" stroke="#000"/>
+  <text x="8" y="30" font-size="26" font-weight="900">Nutrition Facts</text>
+  <text x="8" y="48">{{.Title}}</text>
+  <text x="8" y="64">Serving Size {{.ServingSize}}</text>
+  <rect x="8" y="70" width="264" height="8" fill="#000"/>
+  <text x="8" y="92" font-weight="bold">Amount Per Serving</text>
+  <text x="8" y="110"><tspan font-weight="bold">Calories</tspan> {{.Calories}}</text>
+  <text x="272" y="110" text-anchor="end">Calories from Fat {{.CaloriesFromFat}}</text>
+  <rect x="8" y="116" width="264" height="4" fill="#000"/>
+  <text x="272" y="132" text-anchor="end" font-weight="bold">% Daily Value*</text>
+  {{range .Rows}}<text x="{{.X}}" y="{{.Y}}">{{if .Sub}}{{.Name}}{{else}}<tspan font-weight="bold">{{.Name}}</tspan>{{end}} {{.Amount}}</text>
+  <text x="272" y="{{.Y}}" text-anchor="end" font-weight="bold">{{.DV}}</text>
+  {{end}}<rect x="8" y="{{.VitsTop}}" width="264" height="8" fill="#000"/>
+  {{range .Vits}}<text x="{{.X}}" y="{{.Y}}">{{.Name}}</text>
+  <text x="272" y="{{.Y}}" text-anchor="end">{{.DV}}</text>
+  {{end}}<text x="8" y="{{.Footnote}}" font-size="9">* Percent Daily Values are based on a 2,000 calorie diet.</text>
+</svg>
+`))
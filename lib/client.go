@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// DartmouthURL is where Dartmouth's CWP answers.
+const DartmouthURL = "http://nutrition.dartmouth.edu:8088/cwp"
+
+// Client calls the CWP of a CBORD NetNutrition site. Every site speaks the
+// same JSON-RPC so only the url changes.
+type Client struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// NewClient returns a client for the CWP at url eg: DartmouthURL
+func NewClient(url string) *Client {
+	return &Client{URL: url, HTTP: http.DefaultClient}
+}
+
+// Default is the client for Dartmouth used by the package level functions.
+var Default = NewClient(DartmouthURL)
+
+// AvailableSIDS is Default.AvailableSIDS
+func AvailableSIDS() (map[string]string, error) {
+	return Default.AvailableSIDS()
+}
+
+// SID is Default.SID
+func SID(sidKey string) (string, error) {
+	return Default.SID(sidKey)
+}
+
+// MenuList is Default.MenuList
+func MenuList(sid string) (models.MenuInfoSlice, error) {
+	return Default.MenuList(sid)
+}
+
+// MealList is Default.MealList
+func MealList(sid string) (models.MealInfoSlice, error) {
+	return Default.MealList(sid)
+}
+
+// RecipesMenuMealDate is Default.RecipesMenuMealDate
+func RecipesMenuMealDate(sid string, menu, meal int, date time.Time) (models.RecipeInfoSlice, error) {
+	return Default.RecipesMenuMealDate(sid, menu, meal, date)
+}
+
+// GetNutrients is Default.GetNutrients
+func GetNutrients(id string, r *models.RecipeInfo) (*models.RecipeInfo, error) {
+	return Default.GetNutrients(id, r)
+}
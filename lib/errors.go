@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrUpstreamUnavailable is matched with errors.Is by the errors of requests
+// that couldn't reach CWP or that it answered with a server error. They are
+// worth retrying later.
+var ErrUpstreamUnavailable = errors.New("CWP is unavailable")
+
+// ErrNoSID is matched with errors.Is when CWP didn't give us a SID for a
+// venue. The venue should be skipped.
+var ErrNoSID = errors.New("no SID found")
+
+// ErrSessionExpired is matched with errors.Is by the CWP errors that say the
+// SID we sent is no good. A new SID should be created and the call retried.
+var ErrSessionExpired = errors.New("CWP session expired")
+
+// sessionMarkers are the words, or runs of words, the messages of the CWP
+// errors about SIDs contain. They are matched against whole words so that eg:
+// "considered" doesn't match "sid".
+var sessionMarkers = [][]string{
+	{"session"},
+	{"sid"},
+	{"expired"},
+	{"invalid", "context"},
+}
+
+// hasWords is true if words contains the run of words in marker.
+func hasWords(words, marker []string) bool {
+	for i := 0; i+len(marker) <= len(words); i++ {
+		match := true
+		for j, w := range marker {
+			if words[i+j] != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamError is a request that didn't get an answer from CWP.
+type upstreamError struct {
+	Method string
+	Err    error
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Method, ErrUpstreamUnavailable, e.Err)
+}
+
+// Is makes every upstreamError match ErrUpstreamUnavailable.
+func (e *upstreamError) Is(target error) bool {
+	return target == ErrUpstreamUnavailable
+}
+
+func (e *upstreamError) Unwrap() error {
+	return e.Err
+}
+
+// maxExcerpt is how much of a bad response is kept in ErrBadResponse.
+const maxExcerpt = 200
+
+// ErrBadResponse is a response from CWP that we couldn't make sense of. Body
+// is the start of the response and Err why we couldn't read it.
+type ErrBadResponse struct {
+	Method string
+	Body   string
+	Err    error
+}
+
+// truncate cuts s to at most max bytes without splitting a rune, ok is false
+// if anything was cut.
+func truncate(s string, max int) (string, bool) {
+	if len(s) <= max {
+		return s, true
+	}
+	end := max
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end], false
+}
+
+// badResponse returns an ErrBadResponse with an excerpt of the body.
+func badResponse(method string, body []byte, err error) *ErrBadResponse {
+	excerpt := strings.TrimSpace(string(body))
+	if cut, ok := truncate(excerpt, maxExcerpt); !ok {
+		excerpt = cut + "..."
+	}
+	return &ErrBadResponse{Method: method, Body: excerpt, Err: err}
+}
+
+func (e *ErrBadResponse) Error() string {
+	return fmt.Sprintf("%s: bad response: %s: %q", e.Method, e.Err, e.Body)
+}
+
+func (e *ErrBadResponse) Unwrap() error {
+	return e.Err
+}
+
+// ErrRPC is an error CWP answered with in the error field of its response.
+// Code is 0 when CWP only sent a message.
+type ErrRPC struct {
+	Method  string
+	Code    int
+	Message string
+}
+
+func (e *ErrRPC) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("%s: CWP error %d: %s", e.Method, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s: CWP error: %s", e.Method, e.Message)
+}
+
+// Is makes the errors about our SID match ErrSessionExpired.
+func (e *ErrRPC) Is(target error) bool {
+	if target != ErrSessionExpired {
+		return false
+	}
+	words := strings.FieldsFunc(strings.ToLower(e.Message), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, m := range sessionMarkers {
+		if hasWords(words, m) {
+			return true
+		}
+	}
+	return false
+}
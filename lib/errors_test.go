@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+		ok   bool
+	}{
+		{"short", 10, "short", true},
+		{"exactly10!", 10, "exactly10!", true},
+		{"a bit too long", 5, "a bit", false},
+		// é is 2 bytes, the cut backs off to before it
+		{"caféé", 4, "caf", false},
+		{"caféé", 5, "café", false},
+		// 🍜 is 4 bytes
+		{"🍜🍜", 6, "🍜", false},
+		{"🍜", 3, "", false},
+	}
+	for _, test := range tests {
+		got, ok := truncate(test.s, test.max)
+		if got != test.want || ok != test.ok {
+			t.Errorf("truncate(%q, %d): expected %q %t, got %q %t", test.s, test.max, test.want, test.ok, got, ok)
+		}
+	}
+}
+
+func TestBadResponseExcerptIsValidUTF8(t *testing.T) {
+	body := strings.Repeat("é", maxExcerpt)
+	err := badResponse("test", []byte(body), errors.New("bad"))
+	if !utf8.ValidString(err.Body) {
+		t.Errorf("Expected a valid excerpt, got %q", err.Body)
+	}
+	if !strings.HasSuffix(err.Body, "...") || len(err.Body) > maxExcerpt+3 {
+		t.Errorf("Expected the excerpt to be cut, got %d bytes", len(err.Body))
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	tests := map[string]bool{
+		"Session expired":       true,
+		"Invalid SID.":          true,
+		"invalid context":       true,
+		"Not considered valid":  false,
+		"Missing context value": false,
+		"sessions are fine":     false,
+	}
+	for message, want := range tests {
+		err := &ErrRPC{Method: "test", Message: message}
+		if got := errors.Is(err, ErrSessionExpired); got != want {
+			t.Errorf("%q: expected %t, got %t", message, want, got)
+		}
+	}
+}
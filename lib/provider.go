@@ -0,0 +1,29 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// MenuProvider is where a scrape gets its menus from. Everything after the
+// fetch works on models.VenueInfo so a provider only has to fill one in. The
+// venue keys are the provider's own, SID gives the session a venue's calls
+// use for the providers that have them and "" for the others.
+//
+// Sessions is the provider for CWP and Static the one for menu files.
+type MenuProvider interface {
+	// Venues returns the names of the venues by key.
+	Venues() (map[string]string, error)
+	SID(venue string) (string, error)
+	MenuList(venue string) (models.MenuInfoSlice, error)
+	MealList(venue string) (models.MealInfoSlice, error)
+	RecipesMenuMealDate(venue string, menu, meal int, date time.Time) (models.RecipeInfoSlice, error)
+	// GetNutrients fills in the nutrients of the recipe.
+	GetNutrients(venue string, r *models.RecipeInfo) (*models.RecipeInfo, error)
+}
+
+var (
+	_ MenuProvider = (*Sessions)(nil)
+	_ MenuProvider = (*Static)(nil)
+)
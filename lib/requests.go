@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"strconv"
 	"time"
 	"unicode/utf8"
@@ -13,7 +12,7 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 
-	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/metrics"
 	"github.com/jesusrmoreno/nutrition-scraper/models"
 )
 
@@ -24,20 +23,38 @@ func GetMD5Hash(text string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
+// The CWP methods we call, they label the request metrics.
+const (
+	methodAvailableSIDS = "get_available_sids"
+	methodCreateContext = "create_context"
+	methodMenuList      = "get_webmenu_list"
+	methodMealList      = "get_webmenu_meals_list"
+	methodRecipes       = "get_recipes_for_menumealdate"
+	methodNutrients     = "get_nutrient_label_items"
+)
+
 // makeRequest is a helper function that takes the parameters as a string and
 // executes the http request returning any errors, or nil and the body as a
-// byte array
-func makeRequest(params string) ([]byte, error) {
-	url := urlBuilder()
+// byte array. An error in the error field of the response is returned as an
+// ErrRPC. The method is used for the metrics and the errors.
+func (c *Client) makeRequest(method, params string) ([]byte, error) {
+	url := c.urlBuilder()
+	metrics.Requests.WithLabelValues(method).Inc()
+	start := time.Now()
+	defer func() {
+		metrics.RequestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	}()
 	// Params is a string above and must be turned into a byte array to be sent
-	// with http.Post
+	// with the POST
 	byteParams := []byte(params)
-	res, err := http.Post(url, "application/json", bytes.NewBuffer(byteParams))
+	res, err := c.HTTP.Post(url, "application/json", bytes.NewBuffer(byteParams))
 
 	// If there is an error making the POST request return the error
 	if err != nil {
-		return []byte{}, errors.Wrap(err, 1)
+		metrics.Errors.WithLabelValues(metrics.ErrorRequest).Inc()
+		return []byte{}, &upstreamError{Method: method, Err: err}
 	}
+	defer res.Body.Close()
 
 	// Read the body into b, b will be a byte array representation of the
 	// response
@@ -45,34 +62,77 @@ func makeRequest(params string) ([]byte, error) {
 
 	// If we can't read the response return err
 	if err != nil {
-		return []byte{}, errors.Wrap(err, 1)
+		metrics.Errors.WithLabelValues(metrics.ErrorRequest).Inc()
+		return []byte{}, &upstreamError{Method: method, Err: err}
+	}
+
+	// Server errors are usually CWP being down, anything else we didn't ask
+	// for properly.
+	if res.StatusCode >= 500 {
+		metrics.Errors.WithLabelValues(metrics.ErrorRequest).Inc()
+		return b, &upstreamError{Method: method, Err: fmt.Errorf("status %d", res.StatusCode)}
+	}
+	if res.StatusCode >= 400 {
+		metrics.Errors.WithLabelValues(metrics.ErrorRequest).Inc()
+		return b, badResponse(method, b, fmt.Errorf("status %d", res.StatusCode))
+	}
+
+	// A call that failed on their end still decodes, just with an empty result,
+	// so the error field is checked for every method here.
+	if err := checkRPC(method, b); err != nil {
+		return b, err
 	}
 
 	return b, nil
 }
 
-// urlBuilder is abstracted so that we can change the base url easily and so
-// that we don't have to remember to add the nocache at the end
-func urlBuilder() string {
+// checkRPC returns the error in the error field of the response if there is
+// one. Bodies that aren't JSON objects are left for the caller to report.
+func checkRPC(method string, b []byte) error {
+	envelope := struct {
+		Error models.RPCError `json:"error"`
+	}{}
+	if err := json.Unmarshal(b, &envelope); err != nil || envelope.Error.Empty() {
+		return nil
+	}
+	metrics.Errors.WithLabelValues(metrics.ErrorRPC).Inc()
+	metrics.RPCErrors.WithLabelValues(method).Inc()
+	return &ErrRPC{
+		Method:  method,
+		Code:    envelope.Error.Code,
+		Message: envelope.Error.Message,
+	}
+}
+
+// decodeError counts a response we couldn't decode and returns it as an
+// ErrBadResponse.
+func decodeError(method string, body []byte, err error) *ErrBadResponse {
+	metrics.Errors.WithLabelValues(metrics.ErrorDecode).Inc()
+	return badResponse(method, body, err)
+}
+
+// urlBuilder is abstracted so that we don't have to remember to add the
+// nocache at the end of the client's url
+func (c *Client) urlBuilder() string {
 	// noCache just needs to be a unique int so that their server doesn't return
 	// the same value every time
 	noCache := strconv.FormatInt(time.Now().UnixNano(), 10)
-	return "http://nutrition.dartmouth.edu:8088/cwp?nocache=" + noCache
+	return c.URL + "?nocache=" + noCache
 }
 
 // AvailableSIDS gets the AvailableSIDs and returns them as a map with the keys
 // being the sids options and the values being the display name for the sid eg:
 // 	DDS: 53 Commons
 //  CYC: Courtyard Cafe
-func AvailableSIDS() (map[string]string, error) {
+func (c *Client) AvailableSIDS() (map[string]string, error) {
 
 	availablesIDs := map[string]string{}
 	// The JSON string copied from the Nutrition Website request
 	params := models.AvailableSIDSRequest
 
-	b, err := makeRequest(params)
+	b, err := c.makeRequest(methodAvailableSIDS, params)
 	if err != nil {
-		return availablesIDs, errors.Wrap(err, 1)
+		return availablesIDs, err
 	}
 
 	// Create a struct to hold the response. This allows us to see whether the
@@ -80,20 +140,22 @@ func AvailableSIDS() (map[string]string, error) {
 	// to return early since we can't do anything with it anyway
 	response := models.AvailableSIDSResponse{}
 	if err := json.Unmarshal(b, &response); err != nil {
-		return availablesIDs, errors.Wrap(err, 1)
+		return availablesIDs, decodeError(methodAvailableSIDS, b, err)
 	}
 
-	for _, sidArray := range response.Result.Result {
-		// sidArray[0] currently holds the sid and sidArray[1] holds the display
-		// name. This might change in the future but such is the nature of scrapers
-		availablesIDs[sidArray[0]] = sidArray[1]
-		// We can't check if it indeed holds anything so this will panic if it
-		// can't read in the array.
+	err = decodeRows(methodAvailableSIDS, listItems("result", response.Result.Result), func(r row) func() {
+		// r[0] currently holds the sid and r[1] holds the display name. This
+		// might change in the future but such is the nature of scrapers
+		sid, name := r.String(0), r.String(1)
+		return func() { availablesIDs[sid] = name }
+	})
+	if err := ignorePartial(err); err != nil {
+		return availablesIDs, err
 	}
 
 	// If the map is empty we know something went wrong so we return an error.
 	if len(availablesIDs) == 0 {
-		return availablesIDs, errors.Errorf("No new possible sids")
+		return availablesIDs, decodeError(methodAvailableSIDS, b, fmt.Errorf("no venues listed"))
 	}
 
 	// If we made it this far then our map should contain key:value pairs
@@ -102,68 +164,68 @@ func AvailableSIDS() (map[string]string, error) {
 }
 
 // SID ...
-func SID(sidKey string) (string, error) {
+func (c *Client) SID(sidKey string) (string, error) {
 
 	params := fmt.Sprintf(models.GetSIDSRequest, sidKey)
-	b, err := makeRequest(params)
+	b, err := c.makeRequest(methodCreateContext, params)
 
 	if err != nil {
-		return ``, errors.Wrap(err, 1)
+		return ``, err
 	}
 
 	sidResponse := models.SIDResponse{}
 	if err := json.Unmarshal(b, &sidResponse); err != nil {
-		return ``, errors.Wrap(err, 1)
+		return ``, decodeError(methodCreateContext, b, err)
 	}
 
 	sid := sidResponse.Result.Sid
 	if utf8.RuneCountInString(sid) == 0 {
-		return ``, errors.Errorf("No SID found")
+		return ``, fmt.Errorf("%s: %w", sidKey, ErrNoSID)
 	}
 
 	return sid, nil
 }
 
 // MenuList ...
-func MenuList(sid string) (models.MenuInfoSlice, error) {
+func (c *Client) MenuList(sid string) (models.MenuInfoSlice, error) {
 
 	menuInfos := models.MenuInfoSlice{}
 	params := fmt.Sprintf(models.GetMenuListRequest, sid)
-	b, err := makeRequest(params)
+	b, err := c.makeRequest(methodMenuList, params)
 	// If we can't read the response return err
 	if err != nil {
-		return menuInfos, errors.Wrap(err, 1)
+		return menuInfos, err
 	}
 
 	menuList := models.MenuListResponse{}
 	if err := json.Unmarshal(b, &menuList); err != nil {
-		return menuInfos, errors.Wrap(err, 1)
+		return menuInfos, decodeError(methodMenuList, b, err)
 	}
 
-	for _, v := range menuList.Result.MenusList {
+	err = decodeRows(methodMenuList, listItems("menus_list", menuList.Result.MenusList), func(r row) func() {
 		menu := models.MenuInfo{
-			ID:   int(v[0].(float64)),
-			Name: v[3].(string),
+			ID:   r.Int(0),
+			Name: r.String(3),
 		}
-		menuInfos = append(menuInfos, menu)
-	}
-	return menuInfos, nil
+		return func() { menuInfos = append(menuInfos, menu) }
+	})
+	return menuInfos, ignorePartial(err)
 }
 
 // MealList gets the list of meals from the Dartmouth Nutrition API; It takes
 // the sid for the venue.
-func MealList(sid string) (models.MealInfoSlice, error) {
+func (c *Client) MealList(sid string) (models.MealInfoSlice, error) {
 	params := fmt.Sprintf(models.GetMealListRequest, sid)
 	mealsList := models.MealsListResponse{}
-	b, err := makeRequest(params)
+	b, err := c.makeRequest(methodMealList, params)
 	// Will contain all of our meal info's
 	mealInfoList := models.MealInfoSlice{}
 	if err != nil {
-		return mealInfoList, errors.Wrap(err, 1)
+		return mealInfoList, err
 	}
 
 	if err := json.Unmarshal(b, &mealsList); err != nil {
-		return mealInfoList, errors.Wrap(err, 1)
+		return mealInfoList, decodeError(methodMealList, b, err)
 	}
 
 	// This is a hack to get around the formatting of the response that we get
@@ -175,54 +237,27 @@ func MealList(sid string) (models.MealInfoSlice, error) {
 	// through the keys so that we don't have to have switch statements for each
 	// menu. All in all this makes it so that there is less cognative overhead
 	// at the price of having to use interface and type casting..
-	// If the type conversion fails we return an error to remind programmer to
-	// check the format of the api response
-	for _, value := range mealsList.Result.MealsList {
-		// pretty.Println(value)
-		id, ok := value.([]interface{})[0].(float64)
-		if !ok {
-			return mealInfoList, errors.Errorf("Format of MealsList is incorrect.")
-		}
-		intID := int(id)
-
-		name, ok := value.([]interface{})[2].(string) // string
-		if !ok {
-			return mealInfoList, errors.Errorf("Format of MealsList is incorrect.")
-		}
-
-		code, ok := value.([]interface{})[4].(string) // string
-		if !ok {
-			return mealInfoList, errors.Errorf("Format of MealsList is incorrect.")
-		}
-
-		start, ok := value.([]interface{})[5].(float64)
-		if !ok {
-			return mealInfoList, errors.Errorf("Format of MealsList is incorrect.")
-		}
-		intStart := int(start)
-
-		end, ok := value.([]interface{})[6].(float64)
-		if !ok {
-			return mealInfoList, errors.Errorf("Format of MealsList is incorrect.")
-		}
-		intEnd := int(end)
-
+	// The meals that don't look like we expect are skipped and added to the
+	// drift report to remind the programmer to check the format of the api
+	// response
+	err = decodeRows(methodMealList, keyedItems("meals_list", mealsList.Result.MealsList), func(r row) func() {
 		mealInfo := models.MealInfo{
-			ID:        intID,
-			StartTime: intStart,
-			EndTime:   intEnd,
-			Name:      name,
-			Code:      code,
+			ID:        r.Int(0),
+			Name:      r.String(2),
+			Code:      r.String(4),
+			StartTime: r.Int(5),
+			EndTime:   r.Int(6),
 		}
-		mealInfoList = append(mealInfoList, mealInfo)
-	}
+		return func() { mealInfoList = append(mealInfoList, mealInfo) }
+	})
 
-	return mealInfoList, nil
+	return mealInfoList, ignorePartial(err)
 }
 
 // RecipesMenuMealDate gets the recipes for the provided menu, meal, and date.
-// It takes the menu, meal ids and a time object.
-func RecipesMenuMealDate(sid string, menu, meal int, date time.Time) (models.RecipeInfoSlice, error) {
+// It takes the menu, meal ids and a time object. If some of the recipes
+// couldn't be read the others are returned with a PartialError.
+func (c *Client) RecipesMenuMealDate(sid string, menu, meal int, date time.Time) (models.RecipeInfoSlice, error) {
 
 	// Year and day are returned as ints but Month is a string.
 	// when it converts into an int it will be the corresponding month number..
@@ -232,28 +267,26 @@ func RecipesMenuMealDate(sid string, menu, meal int, date time.Time) (models.Rec
 		Sprintf(models.RecipesMenuMealDate, sid, menu, meal, day, month, year)
 
 	recipes := models.RecipeInfoSlice{}
-	b, err := makeRequest(params)
+	b, err := c.makeRequest(methodRecipes, params)
 	if err != nil {
-		return recipes, errors.Wrap(err, 1)
+		return recipes, err
 	}
 
 	response := models.RecipeResponse{}
 	if err := json.Unmarshal(b, &response); err != nil {
-		return recipes, errors.Wrap(err, 1)
+		return recipes, decodeError(methodRecipes, b, err)
 	}
 
-	for _, recipeRaw := range response.Result.RecipeitemsList {
-		name := recipeRaw[0].(string)
-		category := recipeRaw[1].([]interface{})[0].(string)
-		recipeID := int(recipeRaw[1].([]interface{})[3].(float64))
-		recipeMMID := int(response.Result.MmID)
-		recipeRank := int(recipeRaw[1].([]interface{})[4].(float64))
+	items := listItems("recipeitems_list", response.Result.RecipeitemsList)
+	err = decodeRows(methodRecipes, items, func(r row) func() {
+		// r[1] holds the category, id and rank of the recipe
+		info := r.Row(1)
 		recipe := models.RecipeInfo{
-			Name:     name,
-			Category: category,
-			ID:       recipeID,
-			Rank:     recipeRank,
-			MmID:     recipeMMID,
+			Name:     r.String(0),
+			Category: info.String(0),
+			ID:       info.Int(3),
+			Rank:     info.Int(4),
+			MmID:     response.Result.MmID,
 			MealID:   meal,
 			MenuID:   menu,
 			Date:     date,
@@ -261,23 +294,23 @@ func RecipesMenuMealDate(sid string, menu, meal int, date time.Time) (models.Rec
 			Year:     year,
 			Day:      day,
 		}
-		recipes = append(recipes, recipe)
-	}
+		return func() { recipes = append(recipes, recipe) }
+	})
 
-	return recipes, nil
+	return recipes, err
 }
 
 // GetNutrients ...
-func GetNutrients(id string, r *models.RecipeInfo) (*models.RecipeInfo, error) {
+func (c *Client) GetNutrients(id string, r *models.RecipeInfo) (*models.RecipeInfo, error) {
 	params := fmt.Sprintf(models.GetNutrientsRequest,
 		id, r.MmID, r.ID, r.Rank)
-	b, err := makeRequest(params)
+	b, err := c.makeRequest(methodNutrients, params)
 	if err != nil {
-		return r, errors.Wrap(err, 1)
+		return r, err
 	}
 	response := models.NutrientInfoResponse{}
 	if err := json.Unmarshal(b, &response); err != nil {
-		return r, errors.Errorf(string(b))
+		return r, decodeError(methodNutrients, b, err)
 	}
 
 	r.VenueSID = id
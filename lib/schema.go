@@ -0,0 +1,301 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jesusrmoreno/nutrition-scraper/metrics"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// SchemaError is a value in a CWP response that isn't what we expect. Field is
+// where the value is in the response eg: menus_list[2][3] and Path is where it
+// is in any of the items eg: menus_list[*][3]
+type SchemaError struct {
+	Method string
+	Field  string
+	Path   string
+	Want   string
+	Got    string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s should be %s but is %s", e.Method, e.Field, e.Want, e.Got)
+}
+
+// ErrPartial is matched with errors.Is by the errors of responses where some
+// of the items couldn't be read. What could be read is returned with the
+// error, but it is incomplete so nothing should be removed because it is
+// missing from it.
+var ErrPartial = errors.New("some items were skipped")
+
+// PartialError is a response where Skipped of the items didn't match the
+// schema, First is the first problem found.
+type PartialError struct {
+	Method  string
+	Skipped int
+	First   *SchemaError
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%s: %s: %d skipped, first: %s", e.Method, ErrPartial, e.Skipped, e.First)
+}
+
+// Is makes every PartialError match ErrPartial.
+func (e *PartialError) Is(target error) bool {
+	return target == ErrPartial
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.First
+}
+
+// ignorePartial drops the error of a partial list. Missing a venue, menu or
+// meal only means less is scraped, nothing stored is removed because of it.
+func ignorePartial(err error) error {
+	if errors.Is(err, ErrPartial) {
+		return nil
+	}
+	return err
+}
+
+// kind describes the type of a decoded JSON value.
+func kind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a bool"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("a %T", v)
+}
+
+// decoder reads the positional arrays of a single item in a response. Reading
+// a value that is missing or of the wrong type gives the zero value and the
+// first problem is kept in err, so an item can be read in full and checked
+// once at the end.
+type decoder struct {
+	method string
+	err    *SchemaError
+}
+
+// row is one of the positional arrays being read by a decoder.
+type row struct {
+	d      *decoder
+	at     location
+	values []interface{}
+}
+
+// fail keeps the first problem found.
+func (d *decoder) fail(at location, want, got string) {
+	if d.err == nil {
+		d.err = &SchemaError{
+			Method: d.method,
+			Field:  at.field,
+			Path:   at.path,
+			Want:   want,
+			Got:    got,
+		}
+	}
+}
+
+// location is where a value is, see SchemaError.
+type location struct {
+	field string
+	path  string
+}
+
+// index is the location of the value at i in the array at l.
+func (l location) index(i int) location {
+	return location{fmt.Sprintf("%s[%d]", l.field, i), fmt.Sprintf("%s[%d]", l.path, i)}
+}
+
+// row starts reading v as an array.
+func (d *decoder) row(at location, v interface{}) row {
+	values, ok := v.([]interface{})
+	if !ok {
+		d.fail(at, "an array", kind(v))
+	}
+	return row{d: d, at: at, values: values}
+}
+
+// get returns the value at i or false if it's missing.
+func (r row) get(i int) (interface{}, location, bool) {
+	at := r.at.index(i)
+	if r.d.err != nil {
+		return nil, at, false
+	}
+	if i >= len(r.values) {
+		r.d.fail(at, "present", fmt.Sprintf("missing from an array of %d", len(r.values)))
+		return nil, at, false
+	}
+	return r.values[i], at, true
+}
+
+// String reads the string at i.
+func (r row) String(i int) string {
+	v, at, ok := r.get(i)
+	if !ok {
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		r.d.fail(at, "a string", kind(v))
+	}
+	return s
+}
+
+// Int reads the number at i as an int.
+func (r row) Int(i int) int {
+	v, at, ok := r.get(i)
+	if !ok {
+		return 0
+	}
+	n, ok := v.(float64)
+	if !ok {
+		r.d.fail(at, "a number", kind(v))
+	}
+	return int(n)
+}
+
+// Row reads the array at i.
+func (r row) Row(i int) row {
+	v, at, ok := r.get(i)
+	if !ok {
+		return row{d: r.d, at: at}
+	}
+	return r.d.row(at, v)
+}
+
+// drift holds the schema problems we have seen by method, field and types.
+var drift = struct {
+	sync.Mutex
+	seen map[string]*models.SchemaDrift
+}{seen: map[string]*models.SchemaDrift{}}
+
+// maxExample is how much of the offending item is kept in the drift report.
+const maxExample = 200
+
+// recordDrift adds the problem to the drift report and the metrics. item is
+// the value that couldn't be read, it is kept as an example.
+func recordDrift(e *SchemaError, item interface{}) {
+	metrics.Errors.WithLabelValues(metrics.ErrorSchema).Inc()
+	metrics.SchemaDrift.WithLabelValues(e.Method, e.Path).Inc()
+
+	drift.Lock()
+	defer drift.Unlock()
+	key := e.Method + " " + e.Path + " " + e.Want + " " + e.Got
+	d, ok := drift.seen[key]
+	if !ok {
+		b, _ := json.Marshal(item)
+		example, _ := truncate(string(b), maxExample)
+		d = &models.SchemaDrift{
+			Method:  e.Method,
+			Field:   e.Path,
+			Want:    e.Want,
+			Got:     e.Got,
+			First:   time.Now(),
+			Example: example,
+		}
+		drift.seen[key] = d
+	}
+	d.Count++
+	d.Last = time.Now()
+}
+
+// Drift returns the schema problems seen since the last ResetDrift sorted by
+// method and field.
+func Drift() []models.SchemaDrift {
+	drift.Lock()
+	defer drift.Unlock()
+	ds := []models.SchemaDrift{}
+	for _, d := range drift.seen {
+		ds = append(ds, *d)
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		if ds[i].Method != ds[j].Method {
+			return ds[i].Method < ds[j].Method
+		}
+		return ds[i].Field < ds[j].Field
+	})
+	return ds
+}
+
+// ResetDrift forgets the schema problems seen so far.
+func ResetDrift() {
+	drift.Lock()
+	defer drift.Unlock()
+	drift.seen = map[string]*models.SchemaDrift{}
+}
+
+// item is one of the values in a response.
+type item struct {
+	at    location
+	value interface{}
+}
+
+// listItems returns the items of a list in the response.
+func listItems(field string, values []interface{}) []item {
+	items := []item{}
+	for i, v := range values {
+		items = append(items, item{location{fmt.Sprintf("%s[%d]", field, i), field + "[*]"}, v})
+	}
+	return items
+}
+
+// keyedItems returns the items of an object in the response sorted by key.
+func keyedItems(field string, values map[string]interface{}) []item {
+	keys := []string{}
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := []item{}
+	for _, k := range keys {
+		items = append(items, item{location{fmt.Sprintf("%s[%q]", field, k), field + "[*]"}, values[k]})
+	}
+	return items
+}
+
+// decodeRows reads each of the items as an array with read, which returns a
+// func that keeps what it read. The items that don't match the schema are
+// skipped and added to the drift report instead of being kept. If there were
+// items but none of them could be read the first problem is returned, if only
+// some were skipped a PartialError is.
+func decodeRows(method string, items []item, read func(r row) func()) error {
+	var first *SchemaError
+	decoded, skipped := 0, 0
+	for _, it := range items {
+		d := &decoder{method: method}
+		keep := read(d.row(it.at, it.value))
+		if d.err != nil {
+			recordDrift(d.err, it.value)
+			skipped++
+			if first == nil {
+				first = d.err
+			}
+			continue
+		}
+		keep()
+		decoded++
+	}
+	switch {
+	case first != nil && decoded == 0:
+		return first
+	case first != nil:
+		return &PartialError{Method: method, Skipped: skipped, First: first}
+	}
+	return nil
+}
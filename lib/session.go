@@ -0,0 +1,156 @@
+package lib
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jesusrmoreno/nutrition-scraper/metrics"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// DefaultSessionAge is how long a SID is used before a new one is created even
+// if CWP hasn't complained about it.
+const DefaultSessionAge = 20 * time.Minute
+
+// maxRenewals is how many times a call is retried with a new SID.
+const maxRenewals = 2
+
+// session is the SID of a venue. Its lock is held while the SID is created so
+// that workers asking at the same time wait for it instead of each creating
+// their own.
+type session struct {
+	sync.Mutex
+	sid     string
+	created time.Time
+}
+
+// Sessions caches a SID per venue key for the workers of a scrape to share.
+// When CWP says a SID has expired a new one is created and the call retried.
+type Sessions struct {
+	client *Client
+	maxAge time.Duration
+	// newSID creates a SID for a venue, it is client.SID unless testing.
+	newSID func(key string) (string, error)
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// NewSessions returns an empty cache of the client's SIDs which are renewed
+// once they are older than maxAge, 0 keeps them until CWP says they have
+// expired.
+func NewSessions(c *Client, maxAge time.Duration) *Sessions {
+	return &Sessions{
+		client:   c,
+		maxAge:   maxAge,
+		newSID:   c.SID,
+		sessions: map[string]*session{},
+	}
+}
+
+// session returns the session of the venue adding it if it is new.
+func (s *Sessions) session(key string) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.sessions[key]
+	if !ok {
+		ss = &session{}
+		s.sessions[key] = ss
+	}
+	return ss
+}
+
+// SID returns the SID of the venue creating one if we don't have one or ours
+// is too old.
+func (s *Sessions) SID(key string) (string, error) {
+	ss := s.session(key)
+	ss.Lock()
+	defer ss.Unlock()
+	if ss.sid != "" && (s.maxAge <= 0 || time.Since(ss.created) < s.maxAge) {
+		return ss.sid, nil
+	}
+	sid, err := s.newSID(key)
+	if err != nil {
+		return "", err
+	}
+	metrics.SessionsCreated.WithLabelValues(key).Inc()
+	ss.sid = sid
+	ss.created = time.Now()
+	return sid, nil
+}
+
+// Invalidate forgets the venue's SID if it is still sid, so that when several
+// workers find out it expired only the first one causes a new SID to be made.
+func (s *Sessions) Invalidate(key, sid string) {
+	ss := s.session(key)
+	ss.Lock()
+	defer ss.Unlock()
+	if ss.sid == sid {
+		ss.sid = ""
+	}
+}
+
+// Do calls fn with the venue's SID. If fn fails because the SID expired it
+// is called again with a new one, up to maxRenewals times.
+func (s *Sessions) Do(key string, fn func(sid string) error) error {
+	for attempt := 0; ; attempt++ {
+		sid, err := s.SID(key)
+		if err != nil {
+			return err
+		}
+		err = fn(sid)
+		if err == nil || !errors.Is(err, ErrSessionExpired) || attempt == maxRenewals {
+			return err
+		}
+		s.Invalidate(key, sid)
+		metrics.SessionRenewals.WithLabelValues(key).Inc()
+	}
+}
+
+// Venues is Client.AvailableSIDS
+func (s *Sessions) Venues() (map[string]string, error) {
+	return s.client.AvailableSIDS()
+}
+
+// MenuList is Client.MenuList for the venue.
+func (s *Sessions) MenuList(key string) (models.MenuInfoSlice, error) {
+	var menus models.MenuInfoSlice
+	err := s.Do(key, func(sid string) error {
+		var err error
+		menus, err = s.client.MenuList(sid)
+		return err
+	})
+	return menus, err
+}
+
+// MealList is Client.MealList for the venue.
+func (s *Sessions) MealList(key string) (models.MealInfoSlice, error) {
+	var meals models.MealInfoSlice
+	err := s.Do(key, func(sid string) error {
+		var err error
+		meals, err = s.client.MealList(sid)
+		return err
+	})
+	return meals, err
+}
+
+// RecipesMenuMealDate is Client.RecipesMenuMealDate for the venue.
+func (s *Sessions) RecipesMenuMealDate(key string, menu, meal int, date time.Time) (models.RecipeInfoSlice, error) {
+	var recipes models.RecipeInfoSlice
+	err := s.Do(key, func(sid string) error {
+		var err error
+		recipes, err = s.client.RecipesMenuMealDate(sid, menu, meal, date)
+		return err
+	})
+	return recipes, err
+}
+
+// GetNutrients is Client.GetNutrients for the venue.
+func (s *Sessions) GetNutrients(key string, r *models.RecipeInfo) (*models.RecipeInfo, error) {
+	err := s.Do(key, func(sid string) error {
+		_, err := s.client.GetNutrients(sid, r)
+		return err
+	})
+	return r, err
+}
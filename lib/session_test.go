@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testSessions returns Sessions whose SIDs are sid-1, sid-2 and so on, and
+// the number of SIDs created so far.
+func testSessions(maxAge time.Duration, delay time.Duration) (*Sessions, *int32) {
+	var created int32
+	s := NewSessions(nil, maxAge)
+	s.newSID = func(key string) (string, error) {
+		time.Sleep(delay)
+		return fmt.Sprintf("sid-%d", atomic.AddInt32(&created, 1)), nil
+	}
+	return s, &created
+}
+
+var errExpired = &ErrRPC{Method: "test", Message: "Session expired"}
+
+func TestDoRenewsExpiredSIDs(t *testing.T) {
+	s, created := testSessions(0, 0)
+	used := []string{}
+	err := s.Do("DDS", func(sid string) error {
+		used = append(used, sid)
+		if len(used) == 1 {
+			return errExpired
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(used) != 2 || used[0] != "sid-1" || used[1] != "sid-2" {
+		t.Errorf("Expected sid-1 then sid-2, got %v", used)
+	}
+	if *created != 2 {
+		t.Errorf("Expected 2 SIDs to be created, got %d", *created)
+	}
+	// The new SID is kept for the next call
+	if sid, _ := s.SID("DDS"); sid != "sid-2" {
+		t.Errorf("Expected sid-2 to be kept, got %s", sid)
+	}
+}
+
+func TestDoGivesUpAfterMaxRenewals(t *testing.T) {
+	s, created := testSessions(0, 0)
+	calls := 0
+	err := s.Do("DDS", func(sid string) error {
+		calls++
+		return errExpired
+	})
+	if !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Expected the expired error, got %v", err)
+	}
+	if calls != maxRenewals+1 {
+		t.Errorf("Expected %d calls, got %d", maxRenewals+1, calls)
+	}
+	if int(*created) != maxRenewals+1 {
+		t.Errorf("Expected %d SIDs, got %d", maxRenewals+1, *created)
+	}
+}
+
+func TestDoDoesNotRenewForOtherErrors(t *testing.T) {
+	s, created := testSessions(0, 0)
+	calls := 0
+	err := s.Do("DDS", func(sid string) error {
+		calls++
+		return &ErrRPC{Method: "test", Message: "Not considered a valid menu"}
+	})
+	if err == nil || errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Expected the rpc error, got %v", err)
+	}
+	if calls != 1 || *created != 1 {
+		t.Errorf("Expected a single call with a single SID, got %d calls and %d SIDs", calls, *created)
+	}
+}
+
+func TestInvalidateOnlyDropsMatchingSID(t *testing.T) {
+	s, _ := testSessions(0, 0)
+	if sid, _ := s.SID("DDS"); sid != "sid-1" {
+		t.Fatalf("Expected sid-1, got %s", sid)
+	}
+	s.Invalidate("DDS", "sid-0")
+	if sid, _ := s.SID("DDS"); sid != "sid-1" {
+		t.Errorf("Invalidating another SID dropped ours, got %s", sid)
+	}
+	s.Invalidate("DDS", "sid-1")
+	if sid, _ := s.SID("DDS"); sid != "sid-2" {
+		t.Errorf("Expected a new SID once ours was invalidated, got %s", sid)
+	}
+	// Venues have their own SIDs
+	if sid, _ := s.SID("CYC"); sid != "sid-3" {
+		t.Errorf("Expected CYC to get its own SID, got %s", sid)
+	}
+}
+
+func TestSIDsAreRenewedWhenTooOld(t *testing.T) {
+	s, _ := testSessions(time.Nanosecond, 0)
+	first, _ := s.SID("DDS")
+	time.Sleep(time.Millisecond)
+	if second, _ := s.SID("DDS"); second == first {
+		t.Errorf("Expected a new SID once %s was too old", first)
+	}
+}
+
+func TestConcurrentDoSharesOneSID(t *testing.T) {
+	s, created := testSessions(0, 20*time.Millisecond)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	used := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Do("DDS", func(sid string) error {
+				mu.Lock()
+				used[sid] = true
+				mu.Unlock()
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if *created != 1 {
+		t.Errorf("Expected a single SID to be created, got %d", *created)
+	}
+	if len(used) != 1 || !used["sid-1"] {
+		t.Errorf("Expected every call to use sid-1, got %v", used)
+	}
+}
+
+func TestConcurrentExpiryRenewsOnce(t *testing.T) {
+	s, created := testSessions(0, 5*time.Millisecond)
+	if _, err := s.SID("DDS"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Do("DDS", func(sid string) error {
+				if sid == "sid-1" {
+					return errExpired
+				}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if *created != 2 {
+		t.Errorf("Expected sid-1 to be replaced once, got %d SIDs", *created)
+	}
+}
@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// Static is a MenuProvider serving the menus in VenueInfo files, like the
+// output_<KEY>.json files written by --write-files. Each file is a venue's
+// menus for a day, a venue can have files for several days.
+type Static struct {
+	venues map[string][]models.VenueInfo
+}
+
+// ReadVenueFile reads the VenueInfo in the file at path.
+func ReadVenueFile(path string) (models.VenueInfo, error) {
+	info := models.VenueInfo{}
+	file, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&info); err != nil {
+		return info, fmt.Errorf("%s: %w", path, err)
+	}
+	if info.Key == "" {
+		return info, fmt.Errorf("%s: venue has no key", path)
+	}
+	return info, nil
+}
+
+// NewStatic returns a Static serving the files matching the glob patterns eg:
+// "menus/output_*.json"
+func NewStatic(patterns ...string) (*Static, error) {
+	st := &Static{venues: map[string][]models.VenueInfo{}}
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			info, err := ReadVenueFile(path)
+			if err != nil {
+				return nil, err
+			}
+			st.venues[info.Key] = append(st.venues[info.Key], info)
+		}
+	}
+	return st, nil
+}
+
+// sameDay is true if a and b are on the same day, each in its own timezone.
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// Venues returns the venues there are files for.
+func (st *Static) Venues() (map[string]string, error) {
+	venues := map[string]string{}
+	for key, infos := range st.venues {
+		venues[key] = infos[0].Venue
+	}
+	return venues, nil
+}
+
+// SID is "" for the venues there are files for, the files have no sessions.
+func (st *Static) SID(venue string) (string, error) {
+	if _, ok := st.venues[venue]; !ok {
+		return "", fmt.Errorf("%s: %w", venue, ErrNoSID)
+	}
+	return "", nil
+}
+
+// MenuList returns the menus of every meal in the venue's files.
+func (st *Static) MenuList(venue string) (models.MenuInfoSlice, error) {
+	menus := models.MenuInfoSlice{}
+	seen := map[int]bool{}
+	for _, info := range st.venues[venue] {
+		for _, mm := range info.MealsList {
+			for _, menu := range mm.Menus {
+				if !seen[menu.ID] {
+					seen[menu.ID] = true
+					menus = append(menus, menu)
+				}
+			}
+		}
+	}
+	return menus, nil
+}
+
+// MealList returns the meals in the venue's files.
+func (st *Static) MealList(venue string) (models.MealInfoSlice, error) {
+	meals := models.MealInfoSlice{}
+	seen := map[int]bool{}
+	for _, info := range st.venues[venue] {
+		for _, mm := range info.MealsList {
+			if !seen[mm.Meal.ID] {
+				seen[mm.Meal.ID] = true
+				meals = append(meals, mm.Meal)
+			}
+		}
+	}
+	return meals, nil
+}
+
+// RecipesMenuMealDate returns the recipes of the menu and meal in the venue's
+// file for the date.
+func (st *Static) RecipesMenuMealDate(venue string, menu, meal int, date time.Time) (models.RecipeInfoSlice, error) {
+	recipes := models.RecipeInfoSlice{}
+	for _, info := range st.venues[venue] {
+		if !sameDay(info.Date, date) {
+			continue
+		}
+		for _, r := range info.Recipes {
+			if r.MenuID == menu && r.MealID == meal {
+				recipes = append(recipes, r)
+			}
+		}
+	}
+	return recipes, nil
+}
+
+// GetNutrients copies the nutrients of the recipe from the venue's files.
+func (st *Static) GetNutrients(venue string, r *models.RecipeInfo) (*models.RecipeInfo, error) {
+	for _, info := range st.venues[venue] {
+		for _, other := range info.Recipes {
+			if other.ID == r.ID {
+				r.Nutrients = other.Nutrients
+				return r, nil
+			}
+		}
+	}
+	return r, fmt.Errorf("%s: no nutrients for recipe %d", venue, r.ID)
+}
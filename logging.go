@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/go-errors/errors"
+	prefixed "github.com/x-cray/logrus-prefixed-formatter"
+)
+
+// configureLogging sets the format and level of the logger from the
+// --log-format and --log-level flags.
+func configureLogging(c *cli.Context) error {
+	switch c.String("log-format") {
+	case "json":
+		log.Formatter = &logrus.JSONFormatter{}
+	case "text", "":
+		log.Formatter = new(prefixed.TextFormatter)
+	default:
+		return errors.Errorf("--log-format must be text or json")
+	}
+	level, err := logrus.ParseLevel(c.String("log-level"))
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+	log.Level = level
+	return nil
+}
+
+// newRunID returns a random id for a run.
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
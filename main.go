@@ -2,42 +2,84 @@ package main
 
 import (
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/events"
+	"github.com/jesusrmoreno/nutrition-scraper/label"
 	"github.com/jesusrmoreno/nutrition-scraper/lib"
+	"github.com/jesusrmoreno/nutrition-scraper/metrics"
 	"github.com/jesusrmoreno/nutrition-scraper/models"
+	"github.com/jesusrmoreno/nutrition-scraper/notify"
+	"github.com/jesusrmoreno/nutrition-scraper/rules"
+	"github.com/jesusrmoreno/nutrition-scraper/webhook"
 	"github.com/jesusrmoreno/parse"
 	prefixed "github.com/x-cray/logrus-prefixed-formatter"
 )
 
 var log = logrus.New()
 
+// dateTemplate is the format dates are given to us in eg: --startDate 02/27/16
+const dateTemplate = "01/02/06"
+
 func init() {
 	log.Formatter = new(prefixed.TextFormatter)
 }
 
 // State ...
 type State struct {
-	DB            *parse.Client
+	// Log carries the id of the run so that every line logged during a run
+	// can be found together.
+	Log   *logrus.Entry
+	RunID string
+	// Site is the key of the site whose records are loaded and saved, empty
+	// for the default site.
+	Site          string
+	DB            store
 	Recipes       map[int]models.ParseRecipe
 	Nutrients     map[int]bool
 	Offerings     map[string]models.ParseOffering
 	Subscriptions map[int][]string
-	Notifications map[string]models.ParseNotification
+	// RuleSubscriptions are the subscriptions that match recipes with rules
+	// instead of by id.
+	RuleSubscriptions []ruleSubscription
+	// SubscriptionRecords are the subscriptions as they are stored
+	SubscriptionRecords models.SubscriptionSlice
+	// AllergyProfiles are the declared allergies by user id
+	AllergyProfiles map[string]models.AllergyProfile
+	AvoidLists      map[string]models.ParseAvoidList
+	AllergenAlerts  map[string]models.ParseAllergenAlert
+	Notifications   map[string]models.ParseNotification
+	Digests         map[string]models.ParseDigest
+	// Users are only loaded when we need to contact them
+	Users map[string]models.ParseUser
+	// Events is where changes to the stored data are published
+	Events *events.Bus
+	// RecipeVersions holds the history of each recipe by Dartmouth id
+	RecipeVersions map[int]models.RecipeVersionSlice
+	// Venues maps the venue keys to their display names, it is only filled in
+	// when we need the names eg: when serving.
+	Venues map[string]string
 }
 
-func getNotificationsFromParse(s *State, limit int) []models.ParseNotification {
+// getNotificationsFromParse returns the stored notifications matching the
+// where query, an empty one matches every notification.
+func getNotificationsFromParse(s *State, limit int, where string) []models.ParseNotification {
 	if limit > 1000 {
-		log.Warn("Parse has a max return limit of 1000 objects.")
-		log.Warn("Using 1000 as the limit")
+		s.Log.Warn("Parse has a max return limit of 1000 objects.")
+		s.Log.Warn("Using 1000 as the limit")
 		limit = 1000
 	}
 	skipValue := 0
@@ -48,10 +90,11 @@ func getNotificationsFromParse(s *State, limit int) []models.ParseNotification {
 			Class: "Notification",
 			Limit: limit,
 			Skip:  skipValue,
+			Where: where,
 		}, &newNotifications)
 
 		if errs != nil {
-			log.Fatal("Could not get notifications, status:", status)
+			s.Log.Fatal("Could not get notifications, status:", status)
 		}
 		skipValue += limit
 
@@ -65,8 +108,8 @@ func getNotificationsFromParse(s *State, limit int) []models.ParseNotification {
 
 func getSubscriptionsFromParse(s *State, limit int) models.SubscriptionSlice {
 	if limit > 1000 {
-		log.Warn("Parse has a max return limit of 1000 objects.")
-		log.Warn("Using 1000 as the limit")
+		s.Log.Warn("Parse has a max return limit of 1000 objects.")
+		s.Log.Warn("Using 1000 as the limit")
 		limit = 1000
 	}
 	skipValue := 0
@@ -80,7 +123,7 @@ func getSubscriptionsFromParse(s *State, limit int) models.SubscriptionSlice {
 		}, &newSubscriptions)
 
 		if errs != nil {
-			log.Fatal("Could not get subscriptions, status:", status)
+			s.Log.Fatal("Could not get subscriptions, status:", status)
 		}
 		skipValue += limit
 
@@ -92,10 +135,37 @@ func getSubscriptionsFromParse(s *State, limit int) models.SubscriptionSlice {
 	return subscriptions
 }
 
+func getUsersFromParse(s *State, limit int) []models.ParseUser {
+	if limit > 1000 {
+		s.Log.Warn("Parse has a max return limit of 1000 objects.")
+		s.Log.Warn("Using 1000 as the limit")
+		limit = 1000
+	}
+	users := []models.ParseUser{}
+	skipValue := 0
+	for {
+		newUsers := []models.ParseUser{}
+		status, errs := s.DB.Get(parse.Params{
+			Class: "_User",
+			Limit: limit,
+			Skip:  skipValue,
+		}, &newUsers)
+		if errs != nil {
+			s.Log.Fatal("Could not get users, status:", status)
+		}
+		skipValue += limit
+		if len(newUsers) == 0 {
+			break
+		}
+		users = append(users, newUsers...)
+	}
+	return users
+}
+
 func getRecipesFromParse(s *State, limit int) []models.ParseRecipe {
 	if limit > 1000 {
-		log.Warn("Parse has a max return limit of 1000 objects.")
-		log.Warn("Using 1000 as the limit")
+		s.Log.Warn("Parse has a max return limit of 1000 objects.")
+		s.Log.Warn("Using 1000 as the limit")
 		limit = 1000
 	}
 	returnRecipes := []models.ParseRecipe{}
@@ -108,7 +178,7 @@ func getRecipesFromParse(s *State, limit int) []models.ParseRecipe {
 			Skip:  skipValue,
 		}, &rawRecipes)
 		if errs != nil {
-			log.Fatal("Could not get recipes, status:", status)
+			s.Log.Fatal("Could not get recipes, status:", status)
 		}
 		skipValue += limit
 		if len(rawRecipes) == 0 {
@@ -119,17 +189,67 @@ func getRecipesFromParse(s *State, limit int) []models.ParseRecipe {
 	return returnRecipes
 }
 
+func getRecipeVersionsFromParse(s *State, limit int) []models.ParseRecipeVersion {
+	if limit > 1000 {
+		s.Log.Warn("Parse has a max return limit of 1000 objects.")
+		s.Log.Warn("Using 1000 as the limit")
+		limit = 1000
+	}
+	versions := []models.ParseRecipeVersion{}
+	skipValue := 0
+	for {
+		newVersions := []models.ParseRecipeVersion{}
+		status, errs := s.DB.Get(parse.Params{
+			Class: "RecipeVersion",
+			Limit: limit,
+			Skip:  skipValue,
+		}, &newVersions)
+		if errs != nil {
+			s.Log.Fatal("Could not get recipe versions, status:", status)
+		}
+		skipValue += limit
+		if len(newVersions) == 0 {
+			break
+		}
+		versions = append(versions, newVersions...)
+	}
+	return versions
+}
+
+// loadRecipeVersions fills s.RecipeVersions with every recipe's history sorted
+// by version.
+func loadRecipeVersions(s *State) {
+	for _, v := range getRecipeVersionsFromParse(s, 1000) {
+		if models.SiteOf(v.Site) != models.SiteOf(s.Site) {
+			continue
+		}
+		s.RecipeVersions[v.DartmouthID] = append(s.RecipeVersions[v.DartmouthID], v)
+	}
+	for _, versions := range s.RecipeVersions {
+		sort.Sort(versions)
+	}
+}
+
 func offeringExists(s *State, vK string, m, ml string, d time.Time) bool {
+	return s.Offerings[offeringUUID(s, vK, m, ml, d)].ObjectID() != ""
+}
+
+// offeringUUID identifies the offering of the menu and meal at the venue on
+// the date. The venues of the other sites are only unique within the site so
+// it is part of their UUIDs.
+func offeringUUID(s *State, venue, menu, meal string, d time.Time) string {
 	uuidStr := fmt.Sprintf("%d%d%d%s%s%s",
-		d.Day(), int(d.Month()), d.Year(), m, ml, vK)
-	uuid := lib.GetMD5Hash(uuidStr)
-	return s.Offerings[uuid].ObjectID() != ""
+		d.Day(), int(d.Month()), d.Year(), menu, meal, venue)
+	if site := models.SiteOf(s.Site); site != models.DefaultSite {
+		uuidStr = site + uuidStr
+	}
+	return lib.GetMD5Hash(uuidStr)
 }
 
 func getOfferingsFromParse(s *State, limit int) []models.ParseOffering {
 	if limit > 1000 {
-		log.Warn("Parse has a max return limit of 1000 objects.")
-		log.Warn("Using 1000 as the limit")
+		s.Log.Warn("Parse has a max return limit of 1000 objects.")
+		s.Log.Warn("Using 1000 as the limit")
 		limit = 1000
 	}
 	returnOfferings := []models.ParseOffering{}
@@ -142,7 +262,7 @@ func getOfferingsFromParse(s *State, limit int) []models.ParseOffering {
 			Skip:  skipValue,
 		}, &dbOfferings)
 		if errs != nil {
-			log.Fatal("Could not get offerings, status:", status)
+			s.Log.Fatal("Could not get offerings, status:", status)
 		}
 		skipValue += limit
 		if len(dbOfferings) == 0 {
@@ -155,39 +275,65 @@ func getOfferingsFromParse(s *State, limit int) []models.ParseOffering {
 
 // InitParse ...
 func InitParse(s *State) {
-	dbRecipes := getRecipesFromParse(s, 1000)
-	for _, dbRecipe := range dbRecipes {
-		s.Recipes[dbRecipe.DartmouthID] = dbRecipe
-	}
+	loadRecipes(s)
+	loadRecipeVersions(s)
 
 	dbOfferings := getOfferingsFromParse(s, 1000)
 	for _, dbOffering := range dbOfferings {
+		if models.SiteOf(dbOffering.Site) != models.SiteOf(s.Site) {
+			continue
+		}
 		s.Offerings[dbOffering.UUID] = dbOffering
 	}
 
 	dbSubscriptions := getSubscriptionsFromParse(s, 1000)
+	s.SubscriptionRecords = dbSubscriptions
 	for _, sub := range dbSubscriptions {
+		// The subscribed recipe ids are Dartmouth's, rules apply everywhere.
 		for _, recipe := range sub.Recipes {
+			if models.SiteOf(s.Site) != models.DefaultSite {
+				break
+			}
 			s.Subscriptions[recipe] = append(s.Subscriptions[recipe], sub.User.ObjectID)
 		}
+		for _, text := range sub.Rules {
+			rule, err := rules.Compile(text)
+			if err != nil {
+				s.Log.WithFields(logrus.Fields{
+					"subscription": sub.ObjectID,
+					"rule":         text,
+				}).Error(err)
+				continue
+			}
+			s.RuleSubscriptions = append(s.RuleSubscriptions, ruleSubscription{
+				User: sub.User.ObjectID,
+				Rule: rule,
+			})
+		}
 	}
 
-	dbNotifications := getNotificationsFromParse(s, 1000)
+	dbNotifications := getNotificationsFromParse(s, 1000, siteWhere(s))
 	for _, not := range dbNotifications {
 		s.Notifications[not.UUID] = not
 	}
 
-	log.WithFields(logrus.Fields{
-		"Recipes":   len(dbRecipes),
+	loadDigests(s)
+
+	loadAllergies(s)
+
+	s.Log.WithFields(logrus.Fields{
+		"Recipes":   len(s.Recipes),
 		"Offerings": len(dbOfferings),
 	}).Info("In Database")
 }
 
-func mmRecipes(s *State, meal, menu int, rs models.RecipeInfoSlice) []string {
-	mmR := []string{}
+// mmRecipes returns the Dartmouth ids of the recipes served for the given
+// meal and menu.
+func mmRecipes(meal, menu int, rs models.RecipeInfoSlice) []int {
+	mmR := []int{}
 	for _, r := range rs {
 		if r.MealID == meal && r.MenuID == menu {
-			mmR = append(mmR, s.Recipes[r.ID].ObjectID())
+			mmR = append(mmR, r.ID)
 		}
 	}
 	return mmR
@@ -245,17 +391,23 @@ func SetDietaryInfo(n *models.NutrientInfoResponse, title string) *models.Nutrie
 	return n
 }
 
-func saveRecipes(s *State, v models.VenueInfo) {
+// saveRecipes saves the venue's new recipes and with update also updates the
+// changed ones, otherwise every stored recipe counts as a duplicate. It
+// returns how many were new, updated and duplicates.
+func saveRecipes(s *State, v models.VenueInfo, update bool) models.RunCounts {
+	venueLog := logForVenue(s, v)
 	u := uniqueRecipes(v.Recipes)
-	var duplicates, new int
+	var duplicates, new, updated int
 	for _, recipe := range u {
-		if s.Recipes[recipe.ID].DartmouthID != recipe.ID {
+		stored := s.Recipes[recipe.ID]
+		if stored.DartmouthID != recipe.ID {
 			c := models.CreatedBy{
 				Kind:      "Pointer",
 				ClassName: "_User",
 				ObjectID:  "95xfYTL7GG",
 			}
 			returnObj, status, errs := s.DB.Post(models.ParseRecipe{
+				Site:        s.Site,
 				Name:        models.RemoveMetaData(recipe.Name),
 				Category:    recipe.Category,
 				DartmouthID: recipe.ID,
@@ -266,87 +418,337 @@ func saveRecipes(s *State, v models.VenueInfo) {
 				CreatedBy:   c,
 			})
 			if errs != nil || status == 400 {
-				log.Error(status)
-				log.Error(errors.Errorf("Unable to post recipe with ID: %d", recipe.ID))
+				venueLog.Error(status)
+				venueLog.Error(errors.Errorf("Unable to post recipe with ID: %d", recipe.ID))
 				continue
 			}
 			returnedRecipe := returnObj.(models.ParseRecipe)
 			s.Recipes[recipe.ID] = returnedRecipe
-			log.Debug("Created new recipe with objectId: ", returnedRecipe.ObjectID())
+			saveRecipeVersion(s, returnedRecipe, nil)
+			venueLog.Debug("Created new recipe with objectId: ", returnedRecipe.ObjectID())
+			s.Events.Publish(events.RecipeCreated, returnedRecipe)
 			new++
+		} else if update && updateRecipe(s, stored, recipe) {
+			updated++
 		} else {
 			duplicates++
 		}
 	}
 
-	log.WithFields(logrus.Fields{
+	venueLog.WithFields(logrus.Fields{
 		"Saved":     new,
+		"Updated":   updated,
 		"Duplicate": duplicates,
 	}).Info("Scraped Recipes")
+	return models.RunCounts{
+		NewRecipes:       new,
+		UpdatedRecipes:   updated,
+		DuplicateRecipes: duplicates,
+	}
+}
+
+// updateRecipe compares the scraped recipe with the stored one, if anything
+// changed the stored recipe is updated and a new version is recorded with the
+// changed fields. It returns true if the recipe was updated.
+func updateRecipe(s *State, stored models.ParseRecipe, recipe models.RecipeInfo) bool {
+	recipeLog := s.Log.WithField("recipe", stored.DartmouthID)
+	// If the nutrient request failed every nutrient would look like it changed
+	if !recipe.Nutrients.Result.Success {
+		return false
+	}
+	fresh := stored
+	fresh.Name = models.RemoveMetaData(recipe.Name)
+	fresh.Category = recipe.Category
+	fresh.UUID = lib.GetMD5Hash(fresh.Name)
+	fresh.Nutrients = *SetDietaryInfo(&recipe.Nutrients, recipe.Name)
+
+	changes := models.DiffRecipes(stored, fresh)
+	if len(changes) == 0 {
+		return false
+	}
+
+	// Recipes stored before we kept versions get what we have stored as their
+	// first version so that the history starts from it.
+	if len(s.RecipeVersions[recipe.ID]) == 0 && !saveRecipeVersion(s, stored, nil) {
+		return false
+	}
+
+	x := struct {
+		Name      string                      `json:"name"`
+		Category  string                      `json:"category"`
+		UUID      string                      `json:"uuid"`
+		Nutrients models.NutrientInfoResponse `json:"nutrients"`
+	}{
+		Name:      fresh.Name,
+		Category:  fresh.Category,
+		UUID:      fresh.UUID,
+		Nutrients: fresh.Nutrients,
+	}
+	_, status, errs := s.DB.Put(x, "Recipe", stored.ObjectID())
+	if errs != nil || status == 400 {
+		recipeLog.Error(status)
+		recipeLog.Error(errors.Errorf("Unable to update recipe with ID: %d", recipe.ID))
+		return false
+	}
+	s.Recipes[recipe.ID] = fresh
+	if saveRecipeVersion(s, fresh, changes) {
+		versions := s.RecipeVersions[recipe.ID]
+		s.Events.Publish(events.RecipeUpdated, versions[len(versions)-1])
+	}
+
+	recipeLog.WithFields(logrus.Fields{
+		"recipe":  recipe.ID,
+		"changes": len(changes),
+	}).Info("Recipe Changed")
+	return true
+}
+
+// saveRecipeVersion records r as the recipe's next version. It returns false
+// if the version couldn't be saved.
+func saveRecipeVersion(s *State, r models.ParseRecipe, changes []models.FieldChange) bool {
+	recipeLog := s.Log.WithField("recipe", r.DartmouthID)
+	version := len(s.RecipeVersions[r.DartmouthID]) + 1
+	returnObj, status, errs := s.DB.Post(models.NewRecipeVersion(r, version, changes))
+	if errs != nil || status == 400 {
+		recipeLog.Error(status)
+		recipeLog.Error(errors.Errorf("Unable to post version %d of recipe with ID: %d", version, r.DartmouthID))
+		return false
+	}
+	s.RecipeVersions[r.DartmouthID] = append(s.RecipeVersions[r.DartmouthID],
+		returnObj.(models.ParseRecipeVersion))
+	return true
 }
 
-func saveOfferings(s *State, v models.VenueInfo) {
+// saveOfferings saves the venue's new offerings and with update also
+// reconciles the stored ones with the recipes scraped for them.
+func saveOfferings(s *State, v models.VenueInfo, update bool) {
+	venueLog := logForVenue(s, v)
 	offers := []models.ParseOffering{}
-	duplicates, new := 0, 0
+	duplicates, new, updated := 0, 0, 0
 	for _, item := range v.MealsList {
 		meal := item.Meal
 		for _, menu := range item.Menus {
-			day := v.Date.Day()
-			month := int(v.Date.Month())
-			year := v.Date.Year()
-			uuidStr := fmt.Sprintf(
-				"%d%d%d%s%s%s", day, month, year, menu.Name, meal.Name, v.Key)
-			uuid := lib.GetMD5Hash(uuidStr)
-
-			if s.Offerings[uuid].ObjectID() == "" {
-				rs := mmRecipes(s, meal.ID, menu.ID, v.Recipes)
+			uuid := offeringUUID(s, v.Key, menu.Name, meal.Name, v.Date)
+
+			rs := mmRecipes(meal.ID, menu.ID, v.Recipes)
+			if stored := s.Offerings[uuid]; stored.ObjectID() != "" {
+				// A partial list of recipes would remove the ones we couldn't read.
+				if update && !item.IsPartial(menu.ID) && reconcileOffering(s, stored, rs) {
+					updated++
+				} else {
+					duplicates++
+				}
+			} else {
 				offer := models.ParseOffering{
-					Venue:    v.Key,
-					Day:      v.Date.Day(),
-					Month:    int(v.Date.Month()),
-					Year:     v.Date.Year(),
-					MenuName: menu.Name,
-					MealName: meal.Name,
-					Class:    "Offering",
-					UUID:     uuid,
+					Site:      s.Site,
+					Venue:     v.Key,
+					Day:       v.Date.Day(),
+					Month:     int(v.Date.Month()),
+					Year:      v.Date.Year(),
+					MenuName:  menu.Name,
+					MealName:  meal.Name,
+					RecipeIDs: rs,
+					Class:     "Offering",
+					UUID:      uuid,
 				}
-				for _, s := range rs {
-					offer.AddRecipe(s)
+				for _, id := range rs {
+					offer.AddRecipe(s.Recipes[id].ObjectID())
 				}
 				new++
 				offers = append(offers, offer)
-			} else {
-				duplicates++
 			}
 		}
 	}
 	for _, o := range offers {
 		returnObj, status, errs := s.DB.Post(o)
 		if errs != nil {
-			log.Error(status)
-			log.Error(errors.Errorf("Unable to post recipe with ID: %s", o.UUID))
+			venueLog.Error(status)
+			venueLog.Error(errors.Errorf("Unable to post recipe with ID: %s", o.UUID))
 			continue
 		}
 		offering := returnObj.(models.ParseOffering)
 		s.Offerings[offering.UUID] = offering
-		log.Debug("Created new offering with objectId: ", offering.ObjectID())
+		venueLog.Debug("Created new offering with objectId: ", offering.ObjectID())
+		s.Events.Publish(events.OfferingCreated, offering)
 	}
-	log.WithFields(logrus.Fields{
+	venueLog.WithFields(logrus.Fields{
 		"Saved":     new,
+		"Updated":   updated,
 		"Duplicate": duplicates,
 	}).Info("Scraped Offerings")
 }
 
-func saveToParse(s *State, v models.VenueInfo) {
-	saveRecipes(s, v)
-	saveOfferings(s, v)
+// diffRecipeIDs returns the ids in fresh that aren't in stored and the ids in
+// stored that aren't in fresh.
+func diffRecipeIDs(stored, fresh []int) (added, removed []int) {
+	inStored := map[int]bool{}
+	for _, id := range stored {
+		inStored[id] = true
+	}
+	inFresh := map[int]bool{}
+	for _, id := range fresh {
+		if !inStored[id] && !inFresh[id] {
+			added = append(added, id)
+		}
+		inFresh[id] = true
+	}
+	for _, id := range stored {
+		if !inFresh[id] {
+			removed = append(removed, id)
+			inFresh[id] = true
+		}
+	}
+	return added, removed
+}
+
+// recipeObjectIDs maps Dartmouth ids to the objectIds of the stored recipes.
+func recipeObjectIDs(s *State, ids []int) []string {
+	objectIDs := []string{}
+	for _, id := range ids {
+		if r, ok := s.Recipes[id]; ok {
+			objectIDs = append(objectIDs, r.ObjectID())
+		}
+	}
+	return objectIDs
+}
+
+// reconcileOffering brings a stored offering up to date with the recipes that
+// were just scraped for it. Recipes Dartmouth added since the last scrape are
+// added to the relation and the ones they removed are taken out of it, the
+// change is then published as an OfferingUpdated event. It returns true if the
+// offering changed.
+func reconcileOffering(s *State, stored models.ParseOffering, fresh []int) bool {
+	offeringLog := logForOffering(s, stored)
+	// Offerings saved before we kept the recipe ids have nothing to compare
+	// against so we just fill in the ids. Adding to a relation is idempotent.
+	if stored.RecipeIDs == nil {
+		ops := []models.RelationOp{
+			models.NewRelationOp("AddRelation", "Recipe", recipeObjectIDs(s, fresh)),
+		}
+		if putOfferingRecipes(s, stored, fresh, ops) {
+			stored.RecipeIDs = fresh
+			s.Offerings[stored.UUID] = stored
+		}
+		return false
+	}
+
+	added, removed := diffRecipeIDs(stored.RecipeIDs, fresh)
+	if len(added) == 0 && len(removed) == 0 {
+		return false
+	}
+
+	ops := []models.RelationOp{}
+	if len(added) > 0 {
+		ops = append(ops, models.NewRelationOp(
+			"AddRelation", "Recipe", recipeObjectIDs(s, added)))
+	}
+	if len(removed) > 0 {
+		ops = append(ops, models.NewRelationOp(
+			"RemoveRelation", "Recipe", recipeObjectIDs(s, removed)))
+	}
+	if !putOfferingRecipes(s, stored, fresh, ops) {
+		return false
+	}
+	stored.RecipeIDs = fresh
+	s.Offerings[stored.UUID] = stored
+
+	offeringLog.WithFields(logrus.Fields{
+		"venue":   stored.Venue,
+		"menu":    stored.MenuName,
+		"meal":    stored.MealName,
+		"added":   len(added),
+		"removed": len(removed),
+	}).Info("Offering Changed")
+	s.Events.Publish(events.OfferingUpdated, models.OfferingChange{
+		UUID:     stored.UUID,
+		Venue:    stored.Venue,
+		Day:      stored.Day,
+		Month:    stored.Month,
+		Year:     stored.Year,
+		MenuName: stored.MenuName,
+		MealName: stored.MealName,
+		Added:    added,
+		Removed:  removed,
+	})
+	return true
+}
+
+// putOfferingRecipes stores the recipe ids of an offering and applies the ops
+// to its recipes relation. Parse won't add to and remove from a relation in
+// the same request so each op is sent on its own.
+func putOfferingRecipes(s *State, o models.ParseOffering, ids []int, ops []models.RelationOp) bool {
+	offeringLog := logForOffering(s, o)
+	for _, op := range ops {
+		x := struct {
+			RecipeIDs []int             `json:"recipeIds"`
+			Recipes   models.RelationOp `json:"recipes"`
+		}{
+			RecipeIDs: ids,
+			Recipes:   op,
+		}
+		_, status, errs := s.DB.Put(x, "Offering", o.ObjectID())
+		if errs != nil || status == 400 {
+			offeringLog.Error(status)
+			offeringLog.Error(errors.Errorf("Unable to update offering with ID: %s", o.UUID))
+			return false
+		}
+	}
+	return true
+}
+
+// logScrapeError logs an error from one of the lib calls of a scrape. Errors
+// that CWP answered with are logged apart from the rest and counted in
+// rpcErrors, which can be shared by several goroutines.
+func logScrapeError(l *logrus.Entry, err error, rpcErrors *int32) {
+	var rpc *lib.ErrRPC
+	if stderrors.As(err, &rpc) {
+		atomic.AddInt32(rpcErrors, 1)
+		l.WithFields(logrus.Fields{
+			"method":  rpc.Method,
+			"code":    rpc.Code,
+			"message": rpc.Message,
+		}).Error("CWP Error")
+		return
+	}
+	l.Error(err)
+}
+
+// logForVenue is the log for a venue on the day being scraped.
+func logForVenue(s *State, v models.VenueInfo) *logrus.Entry {
+	return s.Log.WithFields(logrus.Fields{
+		"date":  v.Date.Format(dateTemplate),
+		"venue": v.Key,
+	})
+}
+
+// logForOffering is the log for a single offering.
+func logForOffering(s *State, o models.ParseOffering) *logrus.Entry {
+	return s.Log.WithFields(logrus.Fields{
+		"date":  models.Date(o.Year, time.Month(o.Month), o.Day).Format(dateTemplate),
+		"venue": o.Venue,
+		"meal":  o.MealName,
+		"menu":  o.MenuName,
+	})
+}
+
+func saveToParse(s *State, v models.VenueInfo) models.RunCounts {
+	counts := saveRecipes(s, v, true)
+	saveOfferings(s, v, true)
+	return counts
+}
+
+// createInParse only saves the recipes and offerings of the venue that aren't
+// stored yet, the stored ones are left as they are.
+func createInParse(s *State, v models.VenueInfo) models.RunCounts {
+	counts := saveRecipes(s, v, false)
+	saveOfferings(s, v, false)
+	return counts
 }
 
 // NameToNutrientMigration ...
 func NameToNutrientMigration(s *State) {
 	for _, recipe := range s.Recipes {
 		recipe.Nutrients = *SetDietaryInfo(&recipe.Nutrients, recipe.Name)
-		fmt.Println(models.RemoveMetaData(recipe.Name))
 		recipe.Name = models.RemoveMetaData(recipe.Name)
 
 		x := struct {
@@ -360,138 +762,312 @@ func NameToNutrientMigration(s *State) {
 			ID:        recipe.ObjectID(),
 			UUID:      lib.GetMD5Hash(models.RemoveMetaData(recipe.Name)),
 		}
-		xString, _ := json.Marshal(x)
-		fmt.Println(string(xString))
+		recipeLog := s.Log.WithFields(logrus.Fields{
+			"recipe": recipe.ID,
+			"name":   x.Name,
+		})
+		recipeLog.Debug("Migrating Recipe")
 		_, status, errs := s.DB.Put(x, "Recipe", recipe.ID)
 
 		if errs != nil || status == 400 {
-			log.Error(status)
-			log.Error(errs)
-			log.Error(errors.Errorf("Unable to post recipe with ID: %s", recipe.ID))
+			recipeLog.WithFields(logrus.Fields{
+				"status": status,
+				"errors": errs,
+			}).Error(errors.Errorf("Unable to post recipe with ID: %s", recipe.ID))
 			break
 		}
 		// time.Sleep(1 * time.Second)
 	}
 }
 
-func scrape(c *cli.Context) {
-	log.Info("Initializing Scraper")
+// setupWebhooks sends the events published on s.Events to the endpoints in
+// the webhook configuration file. The returned func waits for the deliveries
+// to finish and should be called before exiting.
+func setupWebhooks(s *State, config string) func() {
+	c, err := webhook.LoadConfig(config)
+	if err != nil {
+		s.Log.Fatal(err)
+	}
+	d, err := webhook.NewDispatcher(c)
+	if err != nil {
+		s.Log.Fatal(err)
+	}
+	s.Events.Subscribe(d.Handle)
+	s.Log.WithFields(logrus.Fields{
+		"endpoints": len(c.Endpoints),
+	}).Info("Webhooks")
+	return d.Close
+}
+
+// newState returns an empty State connected to parse.
+func newState() State {
 	p := parse.Client{
 		BaseURL:       "https://api.parse.com/1",
 		ApplicationID: "BAihtNGpVTx4IJsuuFV5f9LibJGnD1ZBOsnXk9qp",
 		Key:           "zJYR2d3dFN3bXL6vUANZyoVLZ3bcTF7fpXTCrU7s",
 	}
 
-	s := State{
-		DB:            &p,
-		Recipes:       make(map[int]models.ParseRecipe),
-		Nutrients:     make(map[int]bool),
-		Offerings:     make(map[string]models.ParseOffering),
-		Subscriptions: make(map[int][]string),
-		Notifications: make(map[string]models.ParseNotification),
+	runID := newRunID()
+	return State{
+		Log:             log.WithField("run", runID),
+		RunID:           runID,
+		DB:              store{&p},
+		Recipes:         make(map[int]models.ParseRecipe),
+		Nutrients:       make(map[int]bool),
+		Offerings:       make(map[string]models.ParseOffering),
+		Subscriptions:   make(map[int][]string),
+		Notifications:   make(map[string]models.ParseNotification),
+		Digests:         make(map[string]models.ParseDigest),
+		Venues:          make(map[string]string),
+		Events:          events.NewBus(),
+		Users:           make(map[string]models.ParseUser),
+		AllergyProfiles: make(map[string]models.AllergyProfile),
+		AvoidLists:      make(map[string]models.ParseAvoidList),
+		AllergenAlerts:  make(map[string]models.ParseAllergenAlert),
+		RecipeVersions:  make(map[int]models.RecipeVersionSlice),
+	}
+}
+
+func scrape(c *cli.Context) {
+	log.Info("Initializing Scraper")
+	if addr := c.String("metrics-addr"); addr != "" {
+		serveMetrics(addr)
+	}
+	s := newState()
+	if config := c.String("webhooks"); config != "" {
+		closeWebhooks := setupWebhooks(&s, config)
+		defer closeWebhooks()
 	}
 
 	if c.Bool("nameNutrientMigration") {
-		fmt.Println("Running Migration...")
+		s.Log.Info("Running Migration")
 		InitParse(&s)
 		NameToNutrientMigration(&s)
 		return
 	}
 
 	if c.Bool("mock") {
-		log.Info("Mocked Scrape")
+		s.Log.Warn("--mock is deprecated, use: import output_DDS.json")
 		InitParse(&s)
-		file, err := os.Open("output_DDS.json")
-		if err != nil {
-			log.Fatal(err)
-		}
-		info := models.VenueInfo{}
-		if err := json.NewDecoder(file).Decode(&info); err != nil {
-			log.Fatal(err)
-		}
-		saveToParse(&s, info)
-		log.Info("End Mocked Scrape")
+		printImport(os.Stdout, importFiles(&s, []string{"output_DDS.json"}, false), false)
 		return
 	}
-	InitParse(&s)
-	pwd, err := os.Getwd()
+	sites, err := sitesFromContext(c)
 	if err != nil {
-		log.Fatal("Could not get working directory!")
+		s.Log.Fatal(err)
 	}
-
-	if c.Bool("write-files") {
-		fmt.Println()
-		fmt.Println("Output files will be placed in", pwd)
+	opts, err := scrapeOptionsFromContext(c)
+	if err != nil {
+		s.Log.Fatal(err)
+	}
+	opts.Start, err = models.ParseDate(dateTemplate, c.String("startDate"))
+	if err != nil {
+		s.Log.Fatal("Unable to parse date make sure it looks like MM/dd/YY")
 	}
+	opts.Table = os.Stdout
+	// Each site gets its own state so that their recipes never mix, they share
+	// the webhooks.
+	for _, st := range sites {
+		siteState := newState()
+		siteState.Events = s.Events
+		useSite(&siteState, st)
+		InitParse(&siteState)
+		opts.Site = st
+		if err := runScrape(&siteState, opts); err != nil {
+			siteState.Log.Fatal(err)
+		}
+	}
+}
 
-	rDate := ""
-	template := "01/02/06"
-	if rDate = c.String("startDate"); rDate != "" {
-		rDate = c.String("startDate")
+// scrapeFlags configure the scrapes run by both the scraper and the daemon.
+var scrapeFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "write-files, wf",
+		Usage: "If present will write the scraped information to json files.",
+	},
+	cli.BoolFlag{
+		Name:  "save",
+		Usage: "Include to save to parse",
+	},
+	cli.BoolFlag{
+		Name:  "digest",
+		Usage: "Group each user's notifications by date, venue and meal into digests.",
+	},
+	cli.StringFlag{
+		Name:  "digest-window",
+		Value: digestWeek,
+		Usage: "Which days go into the digests, tomorrow or week.",
+	},
+	cli.StringFlag{
+		Name:  "digest-time",
+		Value: "07:00",
+		Usage: "When the digests should be sent. HH:MM",
+	},
+	cli.StringFlag{
+		Name:  "webhooks",
+		Usage: "Path to a webhook configuration file, changes are sent to the endpoints in it.",
+	},
+	cli.StringFlag{
+		Name:  "report",
+		Value: "scrape-report.json",
+		Usage: "Where the run report is written, empty to not write it.",
+	},
+	cli.BoolFlag{
+		Name:  "store-report",
+		Usage: "Also store the run report in parse as a ScrapeRun.",
+	},
+}
+
+// scrapeOptions configure a single scrape.
+type scrapeOptions struct {
+	// Start is the first day scraped, Days is how many days are scraped.
+	Start        time.Time
+	Days         int
+	Save         bool
+	WriteFiles   bool
+	Digest       bool
+	DigestWindow string
+	DigestTime   string
+	Retention    notificationRetention
+	Channels     map[string]notify.Channel
+	// Report is the file the run report is written to and StoreReport stores
+	// it in parse too. The report is also printed as a table to Table if set.
+	Report      string
+	StoreReport bool
+	Table       io.Writer
+	// Site is the site scraped, the state has to be using it too.
+	Site site
+}
+
+// scrapeOptionsFromContext reads the options from the command line flags,
+// other than the days to scrape.
+func scrapeOptionsFromContext(c *cli.Context) (scrapeOptions, error) {
+	opts := scrapeOptions{
+		Days:         7,
+		Save:         c.Bool("save"),
+		WriteFiles:   c.Bool("write-files"),
+		Digest:       c.Bool("digest"),
+		DigestWindow: c.String("digest-window"),
+		DigestTime:   c.String("digest-time"),
+		Retention:    retentionFromContext(c),
+		Channels:     newChannels(c),
+		Report:       c.String("report"),
+		StoreReport:  c.Bool("store-report"),
+	}
+	if opts.Digest && opts.DigestWindow != digestTomorrow && opts.DigestWindow != digestWeek {
+		return opts, errors.Errorf("--digest-window must be tomorrow or week")
 	}
+	return opts, nil
+}
 
-	date, err := time.Parse(template, rDate)
+// runScrape scrapes opts.Days days starting at opts.Start and then creates,
+// delivers and prunes the notifications. s should already be initialized with
+// InitParse. A report of the run is written at the end even if it fails.
+func runScrape(s *State, opts scrapeOptions) (err error) {
+	report := models.NewScrapeRun(s.RunID, time.Now())
+	report.Site = s.Site
+	defer func() { finishReport(s, report, opts, err) }()
+	lib.ResetDrift()
+	pwd, err := os.Getwd()
 	if err != nil {
-		log.Fatal("Unable to parse date make sure it looks like MM/dd/YY")
+		return errors.Errorf("Could not get working directory!")
+	}
+
+	if opts.WriteFiles {
+		s.Log.WithField("dir", pwd).Info("Output files will be placed in")
 	}
 
+	template := dateTemplate
 	dateArray := []time.Time{}
-	for i := 0; i < 7; i++ {
-		dateToAdd := date.AddDate(0, 0, i)
+	for i := 0; i < opts.Days; i++ {
+		dateToAdd := opts.Site.date(opts.Start.AddDate(0, 0, i))
 		dateArray = append(dateArray, dateToAdd)
 	}
-	shouldPost := c.Bool("save")
+	shouldPost := opts.Save
 	notificationsToCreate := []models.Notification{}
+	avoidLists := map[string]*models.ParseAvoidList{}
+	// For CWP the SIDs are shared by every request for a venue during the run
+	// and renewed when CWP says they expired.
+	provider, err := opts.Site.provider()
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+	scrapeStart := time.Now()
 	for _, date := range dateArray {
-		log.WithFields(logrus.Fields{
-			"date": date.Format(template),
-		}).Info("Start Scrape")
+		dateLog := s.Log.WithField("date", date.Format(template))
+		dateLog.Info("Start Scrape")
 
 		// We want to get all Available SIDS
-		sids, err := lib.AvailableSIDS()
+		sids, err := provider.Venues()
 		if err != nil {
-			log.Fatal(err)
+			return errors.Wrap(err, 1)
 		}
-		log.WithFields(logrus.Fields{
+		dateLog.WithFields(logrus.Fields{
 			"count": len(sids),
 		}).Info("SIDS")
+		for key, name := range sids {
+			s.Venues[opts.Site.venueKey(key)] = name
+		}
 
 		// How many nutrition routines we want to make at a time
 		nutritionRoutines := 50
 
+		// key is the venue's key in CWP which the requests use, everything else
+		// uses the site's alias for it.
 		for key, value := range sids {
 			throttleRequests := make(chan bool, nutritionRoutines)
 			defer close(throttleRequests)
-			log.WithFields(logrus.Fields{
-				"venue": key,
-			}).Info("Venue Scrape")
+			venue := opts.Site.venueKey(key)
+			venueLog := dateLog.WithField("venue", venue)
+			venueLog.Info("Venue Scrape")
+			venueStart := time.Now()
+			venueRun := venueReport(s, report, date, venue)
+			var rpcErrors int32
 			info := models.VenueInfo{
 				Date: date,
+				Site: s.Site,
+			}
+			sid, err := provider.SID(key)
+			if stderrors.Is(err, lib.ErrUpstreamUnavailable) {
+				// Every other venue would fail the same way so we stop here.
+				venueRun.Error = err.Error()
+				return errors.Wrap(err, 1)
 			}
-			sid, err := lib.SID(key)
 			if err != nil {
-				log.Error(err)
+				if stderrors.Is(err, lib.ErrNoSID) {
+					venueLog.Warn(err)
+				} else {
+					logScrapeError(venueLog, err, &rpcErrors)
+				}
+				venueRun.Error = err.Error()
+				venueRun.RPCErrors = int(rpcErrors)
 				continue
 			}
 
 			info.Venue = value
-			info.Key = key
+			info.Key = venue
 			info.SID = sid
 
-			info.Menus, err = lib.MenuList(sid)
-			log.WithFields(logrus.Fields{
+			info.Menus, err = provider.MenuList(key)
+			venueLog.WithFields(logrus.Fields{
 				"count": len(info.Menus),
 			}).Info("Got Menus")
 			if err != nil {
-				log.Error(err)
+				logScrapeError(venueLog, err, &rpcErrors)
+				venueRun.Error = err.Error()
+				venueRun.RPCErrors = int(rpcErrors)
 				continue
 			}
 
-			info.Meals, err = lib.MealList(sid)
+			// A venue only counts as successfully scraped if every request for it
+			// worked.
+			var venueFailed, nutrientFailures int32
+			info.Meals, err = provider.MealList(key)
 			if err != nil {
-				log.Error(err)
+				logScrapeError(venueLog, err, &rpcErrors)
+				venueFailed = 1
 			}
-			log.WithFields(logrus.Fields{
+			venueLog.WithFields(logrus.Fields{
 				"count": len(info.Meals),
 			}).Info("Got Meals")
 
@@ -501,40 +1077,29 @@ func scrape(c *cli.Context) {
 					Menus: models.MenuInfoSlice{},
 				}
 				for _, menu := range info.Menus {
-					newRecipes, err := lib.
-						RecipesMenuMealDate(sid, menu.ID, meal.ID, date)
-					if err != nil {
-						log.Error(err)
+					newRecipes, err := provider.
+						RecipesMenuMealDate(key, menu.ID, meal.ID, date)
+					// The recipes we could read are kept but the offering isn't
+					// reconciled with them, the skipped ones would be removed from it.
+					if stderrors.Is(err, lib.ErrPartial) {
+						venueLog.WithFields(logrus.Fields{
+							"meal": meal.Name,
+							"menu": menu.Name,
+						}).Warn(err)
+						menuMeal.Partial = append(menuMeal.Partial, menu.ID)
+						venueFailed = 1
+					} else if err != nil {
+						logScrapeError(venueLog.WithFields(logrus.Fields{
+							"meal": meal.Name,
+							"menu": menu.Name,
+						}), err, &rpcErrors)
+						venueFailed = 1
 						continue
 					}
-					for _, recipe := range newRecipes {
-						if len(s.Subscriptions[recipe.ID]) > 0 {
-							notificationsToCreate = append(notificationsToCreate, models.Notification{
-								RecipeID: recipe.ID,
-								Name:     models.RemoveMetaData(recipe.Name),
-								Day:      date.Day(),
-								Month:    int(date.Month()),
-								Year:     date.Year(),
-								OnDate:   date,
-								MenuName: menu.Name,
-								MealName: meal.Name,
-								Venue:    info.Key,
-							})
-						}
-					}
-					// We need to scrape the recipes so that we can create notifications
-					// but if the offering exists then we can just skip everything else
-					if offeringExists(&s, info.Key, menu.Name, meal.Name, date) {
-						log.WithFields(logrus.Fields{
-							"meal":  meal.ID,
-							"menu":  menu.ID,
-							"venue": info.Key,
-							"date":  date.Format(template),
-						}).Info("Offering Exists")
-						// newRecipes, err := lib.RecipesMenuMealDate(sid, menu.ID, meal.ID, date)
-						continue
-					}
-					if len(newRecipes) > 0 {
+					// Stored offerings are kept even if they are now empty so that the
+					// recipes Dartmouth removed from them get removed when we save.
+					if len(newRecipes) > 0 ||
+						offeringExists(s, info.Key, menu.Name, meal.Name, date) {
 						menuMeal.Menus = append(menuMeal.Menus, menu)
 					}
 					info.Recipes = append(info.Recipes, newRecipes...)
@@ -545,7 +1110,7 @@ func scrape(c *cli.Context) {
 			// This section is the part that benefits the most from concurrency
 			// the top parts finish in about 5 seconds but this will take up to
 			// 15 minutes if done one by one.
-			log.WithFields(logrus.Fields{
+			venueLog.WithFields(logrus.Fields{
 				"count": len(info.Recipes),
 			}).Info("Start Recipe Scrape")
 			for index := range info.Recipes {
@@ -559,9 +1124,17 @@ func scrape(c *cli.Context) {
 					// simply ignore it. We pass &info.Recipes[index] so that the actual
 					// pointer in the info object will be updated, otherwise a copy
 					// will be worked on and we won't see the result
-					_, err := lib.GetNutrients(info.SID, &info.Recipes[index])
+					_, err := provider.GetNutrients(key, &info.Recipes[index])
 					if err != nil {
-						log.Error(err)
+						r := info.Recipes[index]
+						logScrapeError(venueLog.WithFields(logrus.Fields{
+							"meal":   info.Meals.Name(r.MealID),
+							"menu":   info.Menus.Name(r.MenuID),
+							"recipe": r.ID,
+						}), err, &rpcErrors)
+						metrics.NutrientFailures.WithLabelValues(info.Key).Inc()
+						atomic.StoreInt32(&venueFailed, 1)
+						atomic.AddInt32(&nutrientFailures, 1)
 					}
 
 				}(key, index, &info)
@@ -574,102 +1147,132 @@ func scrape(c *cli.Context) {
 				throttleRequests <- true
 			}
 
-			log.WithFields(logrus.Fields{
+			venueLog.WithFields(logrus.Fields{
 				"count": len(info.Recipes),
 			}).Info("Finish Recipe Scrape")
+			metrics.RecipesScraped.WithLabelValues(venue).Add(float64(len(info.Recipes)))
+
+			// The subscriptions are matched once we have the nutrients so that rules
+			// can use them.
+			notificationsToCreate = append(notificationsToCreate,
+				matchSubscriptions(s, info)...)
+			addAvoidItems(s, avoidLists, info)
+			// Everything has been scraped so it is safe to count without atomics.
+			venueRun.Menus = len(info.Menus)
+			venueRun.Meals = len(info.Meals)
+			venueRun.Recipes = len(info.Recipes)
+			venueRun.NutrientFailures = int(nutrientFailures)
+			venueRun.RPCErrors = int(rpcErrors)
+			for _, mm := range info.MealsList {
+				venueRun.Offerings += len(mm.Menus)
+			}
 			if shouldPost {
-				saveToParse(&s, info)
+				saved := saveToParse(s, info)
+				venueRun.NewRecipes = saved.NewRecipes
+				venueRun.UpdatedRecipes = saved.UpdatedRecipes
+				venueRun.DuplicateRecipes = saved.DuplicateRecipes
+			}
+			venueRun.Seconds = time.Since(venueStart).Seconds()
+			venueRun.Failed = atomic.LoadInt32(&venueFailed) != 0
+			if !venueRun.Failed {
+				metrics.LastSuccessfulScrape.WithLabelValues(venue).SetToCurrentTime()
 			}
-			log.WithFields(logrus.Fields{
-				"venue": info.Key,
-			}).Info("Finish Venue Scrape")
+			venueLog.Info("Finish Venue Scrape")
 			// Write a file to the directory it is run under with the output
-			if c.Bool("write-files") {
-				fileName := fmt.Sprintf("output_%s.json", info.Key)
+			if opts.WriteFiles {
+				fileName := opts.Site.outputFile(info.Key)
 				filePath := path.Join(pwd, fileName)
 				b, err := json.MarshalIndent(info, "", "  ")
 				if err != nil {
-					fmt.Println("error:", err)
+					venueLog.Error(err)
+					continue
 				}
-				fmt.Println("Wrote to:", fileName)
 				err = ioutil.WriteFile(filePath, b, 0644)
 				if err != nil {
-					log.Println(err)
+					venueLog.Error(err)
 					continue
 				}
+				venueLog.WithField("file", fileName).Info("Wrote Output")
 			}
 		}
 	}
-	ns := createNotifications(&s, notificationsToCreate)
-	saveNotifications(&s, ns)
-	removeOldNotifications(&s)
-}
-
-func saveNotifications(s *State, ns []models.ParseNotification) {
-	throttleRequests := make(chan bool, 20)
-	skipped := 0
-	defer func(throttleRequests chan bool) {
-		close(throttleRequests)
-		fmt.Println("Duplicates: ", skipped)
-	}(throttleRequests)
-	// We want to fill them up by default..
-	for _, n := range ns {
-		if s.Notifications[n.UUID].UUID != n.UUID {
-			go func(n models.ParseNotification) {
-				defer func() {
-					<-throttleRequests
-				}()
-				_, status, errs := s.DB.Post(n)
-				if status == 200 || status == 201 {
-					fmt.Println("Successfully created notification with ID:", n.UUID)
-				}
-				if errs != nil {
-					fmt.Print(status)
-					fmt.Print(errors.Errorf("Unable to post Notification with ID: %d", n.RecipeID))
-				}
-			}(n)
-			throttleRequests <- true
-		} else {
-			skipped++
+	phase(report, "scrape", scrapeStart)
+
+	notificationsStart := time.Now()
+	ns := createNotifications(s, notificationsToCreate)
+	if opts.Digest {
+		sendAt, err := nextSendTime(opts.DigestTime, time.Now(), models.LocationOf(s.Site))
+		if err != nil {
+			return err
 		}
+		ds := createDigests(ns, opts.DigestWindow, sendAt, time.Now())
+		saveDigests(s, ds)
+	} else {
+		skipped := skippedNotifications(s, ns)
+		reportNotifications(s, report, skipped, saveNotifications(s, ns))
 	}
-	for i := 0; i < cap(throttleRequests); i++ {
-		throttleRequests <- false
-	}
-}
-
-func removeOldNotifications(s *State) {
-	throttleRequests := make(chan bool, 20)
-	defer close(throttleRequests)
-	toDelete := []models.ParseNotification{}
+	phase(report, "notifications", notificationsStart)
+	deliveryStart := time.Now()
+	dispatchNotifications(s, opts.Channels, time.Now())
+	phase(report, "delivery", deliveryStart)
+	saveAvoidLists(s, avoidLists, scrapedDates(report))
+	saveAllergenAlerts(s, checkSubscribedAllergens(s))
+	pruneStart := time.Now()
+	ns = []models.ParseNotification{}
 	for _, n := range s.Notifications {
-		// Deletes things from the day before gives a day leway...
-		if n.OnDate.ISO.Before(time.Now().AddDate(0, 0, -1)) {
-			toDelete = append(toDelete, n)
-		}
-	}
-	for _, n := range toDelete {
-		go func(n models.ParseNotification) {
-			defer func() {
-				<-throttleRequests
-			}()
-			status, errs := s.DB.Delete(parse.Params{
-				Class:    "Notification",
-				ObjectID: n.ObjectID(),
-			}, nil)
-			if status == 200 || status == 201 {
-				fmt.Println("Successfully deleted notification with ID:", n.UUID)
-			}
-			if errs != nil {
-				fmt.Print(status)
-				fmt.Print(errors.Errorf("Unable to delete Notification with ID: %s", n.UUID))
-			}
-		}(n)
-		throttleRequests <- true
+		ns = append(ns, n)
 	}
-	for i := 0; i < cap(throttleRequests); i++ {
-		throttleRequests <- false
+	pruned := pruneNotifications(s, ns, opts.Retention, time.Now(), false)
+	pruned.Log(s.Log)
+	reportPruned(s, report, ns, pruned)
+	ds := []models.ParseDigest{}
+	for _, d := range s.Digests {
+		ds = append(ds, d)
 	}
+	pruneDigests(s, ds, opts.Retention, time.Now(), false).Log(s.Log)
+	phase(report, "prune", pruneStart)
+	return nil
+}
+
+// saveNotifications posts the notifications we don't already have and
+// returns the ones that were created.
+func saveNotifications(s *State, ns []models.ParseNotification) []models.ParseNotification {
+	toPost, skipped := dedupeNotifications(s.Notifications, ns)
+	var createdMu sync.Mutex
+	created := []models.ParseNotification{}
+	throttle(20, len(toPost), func(i int) {
+		n := toPost[i]
+		notificationLog := s.Log.WithFields(logrus.Fields{
+			"uuid":   n.UUID,
+			"recipe": n.RecipeID,
+			"date":   n.Date().Format(dateTemplate),
+			"venue":  n.Venue,
+			"meal":   n.MealName,
+			"menu":   n.MenuName,
+		})
+		returnObj, status, errs := s.DB.Post(n)
+		if errs != nil || (status != 200 && status != 201) {
+			notificationLog.WithField("status", status).
+				Error(errors.Errorf("Unable to post Notification with ID: %d", n.RecipeID))
+			return
+		}
+		notificationLog.Debug("Created Notification")
+		if p, ok := returnObj.(models.ParseNotification); ok {
+			n = p
+		}
+		s.Events.Publish(events.NotificationCreated, n)
+		createdMu.Lock()
+		created = append(created, n)
+		createdMu.Unlock()
+		notificationsMu.Lock()
+		s.Notifications[n.UUID] = n
+		notificationsMu.Unlock()
+	})
+	s.Log.WithFields(logrus.Fields{
+		"Saved":     len(created),
+		"Duplicate": skipped,
+	}).Info("Notifications")
+	return created
 }
 
 func createNotifications(s *State, ns []models.Notification) []models.ParseNotification {
@@ -678,7 +1281,7 @@ func createNotifications(s *State, ns []models.Notification) []models.ParseNotif
 	notificationChan := make(chan models.ParseNotification)
 	go func() {
 		for _, n := range ns {
-			for _, userID := range s.Subscriptions[n.RecipeID] {
+			for _, userID := range n.Users {
 				p := models.ParseNotification{
 					Class:    "Notification",
 					RecipeID: n.RecipeID,
@@ -693,6 +1296,8 @@ func createNotifications(s *State, ns []models.Notification) []models.ParseNotif
 					MenuName: n.MenuName,
 					MealName: n.MealName,
 					Venue:    n.Venue,
+					Site:     n.Site,
+					Pending:  true,
 					For: models.CreatedBy{
 						Kind:      "Pointer",
 						ClassName: "_User",
@@ -719,16 +1324,13 @@ func main() {
 	app.Name = "nutrition-scraper"
 	app.Usage = "A tool for scraping the Dartmouth Dining Services menu."
 	app.Action = scrape
+	app.Before = configureLogging
 	app.Version = "0.1.10"
 	// Add more flags at the end of this slice
 	app.Flags = []cli.Flag{
-		cli.BoolFlag{
-			Name:  "write-files, wf",
-			Usage: "If present will write the scraped information to json files.",
-		},
 		cli.BoolFlag{
 			Name:  "mock",
-			Usage: "Will use output_CYC.json data to post. Must also use --url",
+			Usage: "Deprecated, same as import output_DDS.json",
 		},
 		cli.BoolFlag{
 			Name:  "nameNutrientMigration",
@@ -738,9 +1340,189 @@ func main() {
 			Name:  "startDate, sd",
 			Usage: "Set the date we want to scrape. MM/dd/YY",
 		},
-		cli.BoolFlag{
-			Name:  "save",
-			Usage: "Include to save to parse",
+		cli.StringFlag{
+			Name:   "log-format",
+			Value:  "text",
+			Usage:  "How log lines are written, text or json.",
+			EnvVar: "LOG_FORMAT",
+		},
+		cli.StringFlag{
+			Name:   "log-level",
+			Value:  "info",
+			Usage:  "The least severe level logged: debug, info, warn, error, fatal or panic.",
+			EnvVar: "LOG_LEVEL",
+		},
+		cli.StringFlag{
+			Name:  "metrics-addr",
+			Usage: "If present the Prometheus metrics are served at /metrics on this address while scraping.",
+		},
+	}
+	app.Flags = append(app.Flags, scrapeFlags...)
+	app.Flags = append(app.Flags, siteFlags...)
+	app.Flags = append(app.Flags, deliveryFlags...)
+	app.Flags = append(app.Flags, retentionFlags...)
+	daemonFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "Path to a daemon configuration file with the jobs to run, defaults to a nightly scrape of the next 7 days, an hourly scrape of today, delivering every 15 minutes and a weekly prune.",
+		},
+		cli.DurationFlag{
+			Name:  "jitter",
+			Value: 2 * time.Minute,
+			Usage: "Delay every run by a random duration up to this.",
+		},
+		cli.StringFlag{
+			Name:  "status-addr",
+			Value: "localhost:8081",
+			Usage: "Address the job status is served on.",
+		},
+	}
+	daemonFlags = append(daemonFlags, scrapeFlags...)
+	daemonFlags = append(daemonFlags, siteFlags...)
+	daemonFlags = append(daemonFlags, deliveryFlags...)
+	daemonFlags = append(daemonFlags, retentionFlags...)
+	daemonFlags = append(daemonFlags, healthFlags...)
+	serveFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "addr",
+			Value: ":8080",
+			Usage: "Address for the HTTP server to listen on.",
+		},
+		cli.StringFlag{
+			Name:  "report",
+			Value: "scrape-report.json",
+			Usage: "The run report written by the scraper, /healthz reads the last successful scrape from it.",
+		},
+	}
+	serveFlags = append(serveFlags, healthFlags...)
+	app.Commands = []cli.Command{
+		{
+			Name:   "daemon",
+			Usage:  "Runs scrapes, deliveries and prunes on cron-style schedules.",
+			Action: daemon,
+			Flags:  daemonFlags,
+			Subcommands: []cli.Command{
+				{
+					Name:   "status",
+					Usage:  "Shows the last and next runs of a running daemon's jobs.",
+					Action: daemonStatus,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "status-addr",
+							Value: "localhost:8081",
+							Usage: "Address the daemon serves its status on.",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "notifications",
+			Usage: "Commands for managing the stored notifications.",
+			Subcommands: []cli.Command{
+				{
+					Name:      "seen",
+					Usage:     "Marks notifications as seen.",
+					ArgsUsage: "<uuid|objectId>...",
+					Action:    markNotifications(models.NotificationSeen),
+				},
+				{
+					Name:      "dismiss",
+					Usage:     "Dismisses notifications, they are kept until they expire.",
+					ArgsUsage: "<uuid|objectId>...",
+					Action:    markNotifications(models.NotificationDismissed),
+				},
+				{
+					Name:   "deliver",
+					Usage:  "Delivers the pending notifications and digests to the users that want them now.",
+					Action: deliverCommand,
+					Flags:  append(append([]cli.Flag{}, deliveryFlags...), siteFlags...),
+				},
+				{
+					Name:   "prune",
+					Usage:  "Deletes the notifications and digests that are past their retention.",
+					Action: pruneCommand,
+					Flags: append(append([]cli.Flag{
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "Only report what would be deleted.",
+						},
+					}, retentionFlags...), siteFlags...),
+				},
+				{
+					Name:   "migrate-uuids",
+					Usage:  "Moves the stored notifications to uuids that include the recipe.",
+					Action: migrateNotificationUUIDs,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "Only report what would change.",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "recipe",
+			Usage: "Commands for looking at stored recipes.",
+			Subcommands: []cli.Command{
+				{
+					Name:      "label",
+					Usage:     "Prints the Nutrition Facts label for a recipe.",
+					ArgsUsage: "<dartmouthId|objectId>",
+					Action:    recipeLabel,
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name:  "format, f",
+							Value: label.Text,
+							Usage: "One of text, html or svg.",
+						},
+					}, siteFlags...),
+				},
+				{
+					Name:      "history",
+					Usage:     "Prints every recorded version of a recipe and what changed.",
+					ArgsUsage: "<dartmouthId|objectId>",
+					Action:    recipeHistory,
+					Flags:     siteFlags,
+				},
+			},
+		},
+		{
+			Name:      "report",
+			Usage:     "Prints a run report written by a scrape as a table.",
+			ArgsUsage: "[file]",
+			Action:    reportCommand,
+		},
+		{
+			Name:  "allergies",
+			Usage: "Commands for managing the stored avoid lists.",
+			Subcommands: []cli.Command{
+				{
+					Name:   "migrate-uuids",
+					Usage:  "Moves the stored avoid lists to uuids that can't collide across dates.",
+					Action: migrateAvoidListUUIDs,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "Only report what would change.",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:      "import",
+			Usage:     "Saves the recipes and offerings in output_*.json files written by --write-files to parse.",
+			ArgsUsage: "<file, glob or directory>...",
+			Action:    importCommand,
+			Flags:     importFlags,
+		},
+		{
+			Name:   "serve",
+			Usage:  "Serves the scraped data over HTTP, including a GraphQL endpoint.",
+			Action: serve,
+			Flags:  serveFlags,
 		},
 	}
 	app.Run(os.Args)
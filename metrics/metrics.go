@@ -0,0 +1,153 @@
+// Package metrics holds the Prometheus metrics of the scraper. They are
+// registered with the default registry and served by Handler.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "nutrition_scraper"
+
+// The error types counted by Errors.
+const (
+	// ErrorRequest is a request to the CWP API that failed
+	ErrorRequest = "request"
+	// ErrorDecode is a CWP response we couldn't make sense of
+	ErrorDecode = "decode"
+	// ErrorStore is a row we couldn't write to or delete from parse
+	ErrorStore = "store"
+	// ErrorDelivery is a notification we couldn't deliver
+	ErrorDelivery = "delivery"
+	// ErrorRPC is a CWP response with its error field set
+	ErrorRPC = "rpc"
+	// ErrorSchema is an item in a CWP response that isn't shaped like we
+	// expect
+	ErrorSchema = "schema"
+)
+
+var (
+	// Requests counts the requests made to the CWP API by method eg:
+	// get_webmenu_list.
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cwp_requests_total",
+		Help:      "Requests made to the CWP API by method.",
+	}, []string{"method"})
+
+	// RequestDuration is how long the CWP API takes to respond by method.
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cwp_request_duration_seconds",
+		Help:      "How long the CWP API takes to respond by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	// Errors counts the errors by type, see the Error constants.
+	Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Errors by type.",
+	}, []string{"type"})
+
+	// RecipesScraped counts the recipes scraped by venue key.
+	RecipesScraped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "recipes_scraped_total",
+		Help:      "Recipes scraped by venue.",
+	}, []string{"venue"})
+
+	// NutrientFailures counts the recipes we couldn't get the nutrients of by
+	// venue key.
+	NutrientFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nutrient_fetch_failures_total",
+		Help:      "Recipes whose nutrients couldn't be fetched by venue.",
+	}, []string{"venue"})
+
+	// RowsWritten counts the rows created or updated in parse by class eg:
+	// Recipe.
+	RowsWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_written_total",
+		Help:      "Rows created or updated in parse by entity.",
+	}, []string{"entity"})
+
+	// NotificationsCreated counts the notifications created.
+	NotificationsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_created_total",
+		Help:      "Notifications created.",
+	})
+
+	// NotificationsDeleted counts the notifications deleted.
+	NotificationsDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_deleted_total",
+		Help:      "Notifications deleted.",
+	})
+
+	// RPCErrors counts the CWP responses that had their error field set by
+	// method.
+	RPCErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cwp_rpc_errors_total",
+		Help:      "CWP responses with an error by method.",
+	}, []string{"method"})
+
+	// SessionsCreated counts the SIDs created by venue.
+	SessionsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cwp_sessions_created_total",
+		Help:      "CWP sessions created by venue.",
+	}, []string{"venue"})
+
+	// SessionRenewals counts the calls retried with a new SID because CWP said
+	// the old one expired by venue.
+	SessionRenewals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cwp_session_renewals_total",
+		Help:      "Calls retried with a new CWP session by venue.",
+	}, []string{"venue"})
+
+	// SchemaDrift counts the items in CWP responses that were skipped because
+	// they didn't match the schema by method and the field that didn't.
+	SchemaDrift = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "schema_drift_total",
+		Help:      "Items in CWP responses skipped because they didn't match the schema.",
+	}, []string{"method", "field"})
+
+	// LastSuccessfulScrape is the unix time each venue was last scraped
+	// without errors.
+	LastSuccessfulScrape = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_scrape_timestamp_seconds",
+		Help:      "When each venue was last scraped without errors.",
+	}, []string{"venue"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		Requests,
+		RequestDuration,
+		Errors,
+		RecipesScraped,
+		NutrientFailures,
+		RowsWritten,
+		NotificationsCreated,
+		NotificationsDeleted,
+		RPCErrors,
+		SessionsCreated,
+		SessionRenewals,
+		SchemaDrift,
+		LastSuccessfulScrape,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -20,6 +20,9 @@ type Notification struct {
 	MenuName string    `json:"menuName"`
 	MealName string    `json:"mealName"`
 	Venue    string    `json:"venueKey"`
+	Site     string    `json:"site"`
+	// Users are the ids of the users subscribed to the recipe
+	Users []string `json:"-"`
 }
 
 // DateObject ...
@@ -30,26 +33,79 @@ type DateObject struct {
 
 // ParseNotification object
 type ParseNotification struct {
-	UUID     string     `json:"uuid"`
-	ID       string     `json:"objectId"`
-	Class    string     `json:"-"`
-	RecipeID int        `json:"recipeID"`
-	Name     string     `json:"recipeName"`
-	Day      int        `json:"day"`
-	Month    int        `json:"month"`
-	Year     int        `json:"year"`
-	Seen     bool       `json:"seen"`
-	For      CreatedBy  `json:"for"`
-	OnDate   DateObject `json:"onDate"`
-	MenuName string     `json:"menuName"`
-	MealName string     `json:"mealName"`
-	Venue    string     `json:"venueKey"`
-	Created  time.Time  `json:"createdAt"`
+	UUID     string `json:"uuid"`
+	ID       string `json:"objectId"`
+	Class    string `json:"-"`
+	RecipeID int    `json:"recipeID"`
+	Name     string `json:"recipeName"`
+	Day      int    `json:"day"`
+	Month    int    `json:"month"`
+	Year     int    `json:"year"`
+	Seen     bool   `json:"seen"`
+	// Dismissed notifications are kept until they expire so that the scrape
+	// doesn't create them again, they just aren't shown anymore.
+	Dismissed   bool        `json:"dismissed"`
+	SeenAt      *DateObject `json:"seenAt,omitempty"`
+	DismissedAt *DateObject `json:"dismissedAt,omitempty"`
+	// Pending notifications are waiting to be delivered, usually because the
+	// user was in their quiet hours.
+	Pending     bool        `json:"pending"`
+	DeliveredAt *DateObject `json:"deliveredAt,omitempty"`
+	For         CreatedBy   `json:"for"`
+	OnDate      DateObject  `json:"onDate"`
+	MenuName    string      `json:"menuName"`
+	MealName    string      `json:"mealName"`
+	Venue       string      `json:"venueKey"`
+	Site        string      `json:"site"`
+	Created     time.Time   `json:"createdAt"`
 }
 
-// GenerateUUID gives UUID
+// The states a notification can be in, a notification starts out unseen.
+const (
+	NotificationUnseen    = "unseen"
+	NotificationSeen      = "seen"
+	NotificationDismissed = "dismissed"
+)
+
+// State gives the lifecycle state of the notification.
+func (o ParseNotification) State() string {
+	switch {
+	case o.Dismissed:
+		return NotificationDismissed
+	case o.Seen:
+		return NotificationSeen
+	default:
+		return NotificationUnseen
+	}
+}
+
+// Date is midnight of the day the notification is for in its site's
+// timezone.
+func (o ParseNotification) Date() time.Time {
+	return time.Date(o.Year, time.Month(o.Month), o.Day, 0, 0, 0, 0, LocationOf(o.Site))
+}
+
+// ExpiresAt is when the day the notification is for is over.
+func (o ParseNotification) ExpiresAt() time.Time {
+	return o.Date().AddDate(0, 0, 1)
+}
+
+// Expired is true once the day the notification is for is over.
+func (o ParseNotification) Expired(now time.Time) bool {
+	return !now.Before(o.ExpiresAt())
+}
+
+// GenerateUUID gives the UUID identifying the notification. Every (user,
+// recipe, date, venue, meal, menu) gets its own UUID, the parts are separated
+// so that eg: the 1st of November and the 11th of January don't collide.
 func (o ParseNotification) GenerateUUID() string {
-	uuidStr := fmt.Sprintf("%d%d%d%s%s%s%s", o.Month, o.Day, o.Year, o.MenuName, o.MealName, o.Venue, o.For.ObjectID)
+	uuidStr := fmt.Sprintf("%s|%d|%d|%d|%d|%s|%s|%s",
+		o.For.ObjectID, o.RecipeID, o.Year, o.Month, o.Day, o.Venue, o.MealName, o.MenuName)
+	// The other sites have their own recipe ids and venues so they are part of
+	// the UUID, the default site's UUIDs stay the way they were.
+	if site := SiteOf(o.Site); site != DefaultSite {
+		uuidStr = site + "|" + uuidStr
+	}
 	return GetMD5Hash(uuidStr)
 }
 
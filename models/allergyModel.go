@@ -0,0 +1,210 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jesusrmoreno/parse"
+)
+
+// The allergens a user can declare, they match the flags SetDietaryInfo sets.
+// Gluten is the odd one out since Dartmouth marks the recipes that are gluten
+// free, so every recipe that isn't marked gluten free is treated as having it.
+const (
+	AllergenEggs      = "eggs"
+	AllergenFish      = "fish"
+	AllergenDairy     = "dairy"
+	AllergenTreeNuts  = "treeNuts"
+	AllergenPeanuts   = "peanuts"
+	AllergenPork      = "pork"
+	AllergenSoy       = "soy"
+	AllergenShellFish = "shellFish"
+	AllergenWheat     = "wheat"
+	AllergenGluten    = "gluten"
+)
+
+// Allergens returns the allergens the recipe has, sorted.
+func Allergens(n NutrientInfoResponse) []string {
+	r := n.Result
+	flags := map[string]bool{
+		AllergenEggs:      r.Eggs,
+		AllergenFish:      r.Fish,
+		AllergenDairy:     r.Dairy,
+		AllergenTreeNuts:  r.TreeNuts,
+		AllergenPeanuts:   r.Peanuts,
+		AllergenPork:      r.Pork,
+		AllergenSoy:       r.Soy,
+		AllergenShellFish: r.ShellFish,
+		AllergenWheat:     r.Wheat,
+		AllergenGluten:    !r.Gluten,
+	}
+	allergens := []string{}
+	for name, has := range flags {
+		if has {
+			allergens = append(allergens, name)
+		}
+	}
+	sort.Strings(allergens)
+	return allergens
+}
+
+// AllergyProfile holds the allergens a user has declared.
+type AllergyProfile struct {
+	User struct {
+		Type      string `json:"__type"`
+		ClassName string `json:"className"`
+		ObjectID  string `json:"objectId"`
+	} `json:"User"`
+	CreatedAt time.Time `json:"createdAt"`
+	ObjectID  string    `json:"objectId"`
+	Allergens []string  `json:"allergens"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Conflicts returns the profile's allergens that the recipe has.
+func (p AllergyProfile) Conflicts(n NutrientInfoResponse) []string {
+	declared := map[string]bool{}
+	for _, a := range p.Allergens {
+		declared[strings.ToLower(a)] = true
+	}
+	conflicts := []string{}
+	for _, a := range Allergens(n) {
+		if declared[strings.ToLower(a)] {
+			conflicts = append(conflicts, a)
+		}
+	}
+	return conflicts
+}
+
+// AvoidItem is a recipe a user should avoid and why.
+type AvoidItem struct {
+	RecipeID  int      `json:"recipeID"`
+	Name      string   `json:"recipeName"`
+	Venue     string   `json:"venueKey"`
+	MealName  string   `json:"mealName"`
+	MenuName  string   `json:"menuName"`
+	Allergens []string `json:"allergens"`
+}
+
+// ParseAvoidList is everything being served on a day that has one of the
+// user's allergens.
+type ParseAvoidList struct {
+	UUID    string      `json:"uuid"`
+	ID      string      `json:"objectId"`
+	Class   string      `json:"-"`
+	Day     int         `json:"day"`
+	Month   int         `json:"month"`
+	Year    int         `json:"year"`
+	For     CreatedBy   `json:"for"`
+	OnDate  DateObject  `json:"onDate"`
+	Site    string      `json:"site"`
+	Items   []AvoidItem `json:"items"`
+	Created time.Time   `json:"createdAt"`
+}
+
+// GenerateUUID gives the UUID identifying the avoid list. Every (user, date)
+// gets its own UUID, the parts are separated so that eg: the 1st of November
+// and the 11th of January don't collide.
+func (o ParseAvoidList) GenerateUUID() string {
+	uuidStr := fmt.Sprintf("%s|%d|%d|%d", o.For.ObjectID, o.Year, o.Month, o.Day)
+	if site := SiteOf(o.Site); site != DefaultSite {
+		uuidStr = site + "|" + uuidStr
+	}
+	return GetMD5Hash(uuidStr)
+}
+
+// Date is midnight of the day the avoid list is for in its site's timezone.
+func (o ParseAvoidList) Date() time.Time {
+	return time.Date(o.Year, time.Month(o.Month), o.Day, 0, 0, 0, 0, LocationOf(o.Site))
+}
+
+// SetID ...
+func (o ParseAvoidList) SetID(id string) parse.Object {
+	o.ID = id
+	return o
+}
+
+// SetClass ...
+func (o ParseAvoidList) SetClass(class string) parse.Object {
+	o.Class = class
+	return o
+}
+
+// JSON ...
+func (o ParseAvoidList) JSON() (string, error) {
+	j, err := json.Marshal(o)
+	return string(j), err
+}
+
+// ClassName ...
+func (o ParseAvoidList) ClassName() string {
+	return o.Class
+}
+
+// ObjectID ...
+func (o ParseAvoidList) ObjectID() string {
+	return o.ID
+}
+
+// CreatedAt ...
+func (o ParseAvoidList) CreatedAt() time.Time {
+	return o.Created
+}
+
+// ParseAllergenAlert tells a user that the allergens of a recipe they are
+// subscribed to changed since they subscribed.
+type ParseAllergenAlert struct {
+	UUID     string    `json:"uuid"`
+	ID       string    `json:"objectId"`
+	Class    string    `json:"-"`
+	For      CreatedBy `json:"for"`
+	RecipeID int       `json:"recipeID"`
+	Name     string    `json:"recipeName"`
+	// Version is the recipe version the alert was raised for
+	Version int       `json:"version"`
+	Before  []string  `json:"before"`
+	After   []string  `json:"after"`
+	Seen    bool      `json:"seen"`
+	Created time.Time `json:"createdAt"`
+}
+
+// GenerateUUID gives UUID
+func (o ParseAllergenAlert) GenerateUUID() string {
+	return GetMD5Hash(fmt.Sprintf("%d-%d-%s", o.RecipeID, o.Version, o.For.ObjectID))
+}
+
+// SetID ...
+func (o ParseAllergenAlert) SetID(id string) parse.Object {
+	o.ID = id
+	return o
+}
+
+// SetClass ...
+func (o ParseAllergenAlert) SetClass(class string) parse.Object {
+	o.Class = class
+	return o
+}
+
+// JSON ...
+func (o ParseAllergenAlert) JSON() (string, error) {
+	j, err := json.Marshal(o)
+	return string(j), err
+}
+
+// ClassName ...
+func (o ParseAllergenAlert) ClassName() string {
+	return o.Class
+}
+
+// ObjectID ...
+func (o ParseAllergenAlert) ObjectID() string {
+	return o.ID
+}
+
+// CreatedAt ...
+func (o ParseAllergenAlert) CreatedAt() time.Time {
+	return o.Created
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"strings"
+	"time"
+	// The timezone database is embedded so that Location loads on systems
+	// without one.
+	_ "time/tzdata"
+
+	"github.com/go-errors/errors"
+)
+
+// Location is the timezone of the dining halls. The menus are for days in
+// this timezone so every date we scrape, store or compare is midnight here,
+// whatever the timezone of the server is.
+var Location = loadLocation("America/New_York")
+
+// loadLocation loads the named timezone. It panics if the timezone can't be
+// loaded, a fixed offset would put every date in the summer an hour off.
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// Date returns midnight of the day in Location. Days past the end of the month
+// roll over like they do with time.Date.
+func Date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, Location)
+}
+
+// Day returns midnight of the day it is in Location at t.
+func Day(t time.Time) time.Time {
+	return DayIn(t, Location)
+}
+
+// DayIn returns midnight of the day it is in loc at t.
+func DayIn(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// ParseDate parses value as a day in Location.
+func ParseDate(layout, value string) (time.Time, error) {
+	return time.ParseInLocation(layout, value, Location)
+}
+
+// ClockRange is a daily range of local times like 22:00-07:00, ranges that
+// end before they start wrap around midnight.
+type ClockRange struct {
+	// Start and End are minutes after midnight.
+	Start int
+	End   int
+}
+
+// ParseClockRange parses a range that looks like 22:00-07:00.
+func ParseClockRange(text string) (ClockRange, error) {
+	parts := strings.Split(text, "-")
+	if len(parts) != 2 {
+		return ClockRange{}, errors.Errorf("Unable to parse %q make sure it looks like HH:MM-HH:MM", text)
+	}
+	minutes := []int{}
+	for _, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return ClockRange{}, errors.Errorf("Unable to parse %q make sure it looks like HH:MM-HH:MM", text)
+		}
+		minutes = append(minutes, t.Hour()*60+t.Minute())
+	}
+	return ClockRange{Start: minutes[0], End: minutes[1]}, nil
+}
+
+// Contains is true if the clock shows a time in the range at t. The start is
+// part of the range, the end isn't.
+func (r ClockRange) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if r.Start <= r.End {
+		return m >= r.Start && m < r.End
+	}
+	return m >= r.Start || m < r.End
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jesusrmoreno/parse"
+)
+
+// DigestItem is one of the subscribed recipes listed in a digest.
+type DigestItem struct {
+	RecipeID int    `json:"recipeID"`
+	Name     string `json:"recipeName"`
+	MenuName string `json:"menuName"`
+}
+
+// ParseDigest groups all of a user's notifications for a single date, venue
+// and meal so that they get one notification instead of one per recipe.
+type ParseDigest struct {
+	UUID   string     `json:"uuid"`
+	ID     string     `json:"objectId"`
+	Class  string     `json:"-"`
+	Day    int        `json:"day"`
+	Month  int        `json:"month"`
+	Year   int        `json:"year"`
+	Seen   bool       `json:"seen"`
+	For    CreatedBy  `json:"for"`
+	OnDate DateObject `json:"onDate"`
+	SendAt DateObject `json:"sendAt"`
+	// Pending digests haven't been delivered yet, they are delivered once it
+	// is past SendAt.
+	Pending     bool         `json:"pending"`
+	DeliveredAt *DateObject  `json:"deliveredAt,omitempty"`
+	MealName    string       `json:"mealName"`
+	Venue       string       `json:"venueKey"`
+	Site        string       `json:"site"`
+	Items       []DigestItem `json:"items"`
+	Created     time.Time    `json:"createdAt"`
+}
+
+// GenerateUUID gives the UUID identifying the digest. Every (user, date,
+// venue, meal) gets its own UUID, the parts are separated so that eg: the 1st
+// of November and the 11th of January don't collide.
+func (o ParseDigest) GenerateUUID() string {
+	uuidStr := fmt.Sprintf("%s|%d|%d|%d|%s|%s",
+		o.For.ObjectID, o.Year, o.Month, o.Day, o.Venue, o.MealName)
+	if site := SiteOf(o.Site); site != DefaultSite {
+		uuidStr = site + "|" + uuidStr
+	}
+	return GetMD5Hash(uuidStr)
+}
+
+// Date is midnight of the day the digest is for in its site's timezone.
+func (o ParseDigest) Date() time.Time {
+	return time.Date(o.Year, time.Month(o.Month), o.Day, 0, 0, 0, 0, LocationOf(o.Site))
+}
+
+// ExpiresAt is when the day the digest is for is over.
+func (o ParseDigest) ExpiresAt() time.Time {
+	return o.Date().AddDate(0, 0, 1)
+}
+
+// State gives the lifecycle state of the digest, digests can't be dismissed
+// so they are either seen or unseen.
+func (o ParseDigest) State() string {
+	if o.Seen {
+		return NotificationSeen
+	}
+	return NotificationUnseen
+}
+
+// Notifications expands the digest back into a notification per item.
+func (o ParseDigest) Notifications() []ParseNotification {
+	ns := []ParseNotification{}
+	for _, item := range o.Items {
+		ns = append(ns, ParseNotification{
+			RecipeID: item.RecipeID,
+			Name:     item.Name,
+			Day:      o.Day,
+			Month:    o.Month,
+			Year:     o.Year,
+			For:      o.For,
+			OnDate:   o.OnDate,
+			MenuName: item.MenuName,
+			MealName: o.MealName,
+			Venue:    o.Venue,
+			Site:     o.Site,
+		})
+	}
+	return ns
+}
+
+// SetID ...
+func (o ParseDigest) SetID(id string) parse.Object {
+	o.ID = id
+	return o
+}
+
+// SetClass ...
+func (o ParseDigest) SetClass(class string) parse.Object {
+	o.Class = class
+	return o
+}
+
+// JSON ...
+func (o ParseDigest) JSON() (string, error) {
+	j, err := json.Marshal(o)
+	return string(j), err
+}
+
+// ClassName ...
+func (o ParseDigest) ClassName() string {
+	return o.Class
+}
+
+// ObjectID ...
+func (o ParseDigest) ObjectID() string {
+	return o.ID
+}
+
+// CreatedAt ...
+func (o ParseDigest) CreatedAt() time.Time {
+	return o.Created
+}
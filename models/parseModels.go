@@ -9,6 +9,7 @@ import (
 
 // ParseOffering ...
 type ParseOffering struct {
+	Site     string `json:"site"`
 	Venue    string `json:"venueKey"`
 	ID       string `json:"objectId"`
 	Day      int    `json:"day"`
@@ -16,13 +17,47 @@ type ParseOffering struct {
 	Year     int    `json:"year"`
 	MenuName string `json:"menuName"`
 	MealName string `json:"mealName"`
-	Recipes  struct {
-		Op      string   `json:"__op"`
-		Objects []Object `json:"objects"`
-	} `json:"recipes"`
-	Class   string    `json:"-"`
-	Created time.Time `json:"-"`
-	UUID    string    `json:"uuid"`
+	// RecipeIDs mirrors the Recipes relation with the Dartmouth ids so that the
+	// offering can be resolved without querying the relation.
+	RecipeIDs []int      `json:"recipeIds"`
+	Recipes   RelationOp `json:"recipes"`
+	Class     string     `json:"-"`
+	Created   time.Time  `json:"-"`
+	UUID      string     `json:"uuid"`
+}
+
+// RelationOp is a parse operation on a relation eg: AddRelation
+type RelationOp struct {
+	Op      string   `json:"__op"`
+	Objects []Object `json:"objects"`
+}
+
+// NewRelationOp returns the op that adds or removes the objects of the class
+// from a relation.
+func NewRelationOp(op, className string, objectIDs []string) RelationOp {
+	r := RelationOp{Op: op, Objects: []Object{}}
+	for _, id := range objectIDs {
+		r.Objects = append(r.Objects, Object{
+			Type:      "Pointer",
+			Classname: className,
+			ObjectID:  id,
+		})
+	}
+	return r
+}
+
+// OfferingChange describes the recipes added to and removed from an offering
+// since it was last scraped.
+type OfferingChange struct {
+	UUID     string `json:"uuid"`
+	Venue    string `json:"venueKey"`
+	Day      int    `json:"day"`
+	Month    int    `json:"month"`
+	Year     int    `json:"year"`
+	MenuName string `json:"menuName"`
+	MealName string `json:"mealName"`
+	Added    []int  `json:"added"`
+	Removed  []int  `json:"removed"`
 }
 
 // Object ...
@@ -88,6 +123,7 @@ type ParseRecipe struct {
 	Class       string               `json:"-"`
 	Created     time.Time            `json:"createdAt"`
 	Updated     time.Time            `json:"updatedAt"`
+	Site        string               `json:"site"`
 	Name        string               `json:"name"`
 	Category    string               `json:"category"`
 	DartmouthID int                  `json:"dartmouthId"`
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jesusrmoreno/parse"
+)
+
+// FieldChange is a single field that differs between two versions of a
+// recipe. Nutrients are named by their json key eg: calories, fat_p
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// ParseRecipeVersion is a snapshot of a recipe. The first version is taken
+// when the recipe is created and a new one every time a scrape finds that the
+// recipe changed.
+type ParseRecipeVersion struct {
+	ID          string               `json:"objectId"`
+	Class       string               `json:"-"`
+	Created     time.Time            `json:"createdAt"`
+	UUID        string               `json:"uuid"`
+	Site        string               `json:"site"`
+	Recipe      Object               `json:"recipe"`
+	DartmouthID int                  `json:"dartmouthId"`
+	Version     int                  `json:"version"`
+	Name        string               `json:"name"`
+	Category    string               `json:"category"`
+	Nutrients   NutrientInfoResponse `json:"nutrients"`
+	Changes     []FieldChange        `json:"changes"`
+}
+
+// NewRecipeVersion snapshots the recipe as the given version.
+func NewRecipeVersion(r ParseRecipe, version int, changes []FieldChange) ParseRecipeVersion {
+	uuidStr := fmt.Sprintf("%d-%d", r.DartmouthID, version)
+	// Recipe ids are only unique within a site
+	if site := SiteOf(r.Site); site != DefaultSite {
+		uuidStr = site + "-" + uuidStr
+	}
+	return ParseRecipeVersion{
+		Class: "RecipeVersion",
+		Site:  r.Site,
+		UUID:  GetMD5Hash(uuidStr),
+		Recipe: Object{
+			Type:      "Pointer",
+			Classname: "Recipe",
+			ObjectID:  r.ObjectID(),
+		},
+		DartmouthID: r.DartmouthID,
+		Version:     version,
+		Name:        r.Name,
+		Category:    r.Category,
+		Nutrients:   r.Nutrients,
+		Changes:     changes,
+	}
+}
+
+// ignoredNutrientFields change between requests without the recipe changing so
+// we don't count them as changes.
+var ignoredNutrientFields = map[string]bool{
+	"message": true,
+	"output":  true,
+	"success": true,
+}
+
+// DiffRecipes returns the fields that differ between the stored recipe and
+// the freshly scraped one, or an empty slice if nothing changed.
+func DiffRecipes(old, new ParseRecipe) []FieldChange {
+	changes := []FieldChange{}
+	if old.Name != new.Name {
+		changes = append(changes, FieldChange{"name", old.Name, new.Name})
+	}
+	if old.Category != new.Category {
+		changes = append(changes, FieldChange{"category", old.Category, new.Category})
+	}
+
+	o := reflect.ValueOf(old.Nutrients.Result)
+	n := reflect.ValueOf(new.Nutrients.Result)
+	for i := 0; i < o.NumField(); i++ {
+		field := strings.Split(o.Type().Field(i).Tag.Get("json"), ",")[0]
+		if ignoredNutrientFields[field] {
+			continue
+		}
+		oldValue := fmt.Sprint(o.Field(i).Interface())
+		newValue := fmt.Sprint(n.Field(i).Interface())
+		if oldValue != newValue {
+			changes = append(changes, FieldChange{field, oldValue, newValue})
+		}
+	}
+	return changes
+}
+
+// ClassName ...
+func (o ParseRecipeVersion) ClassName() string {
+	return o.Class
+}
+
+// ObjectID ...
+func (o ParseRecipeVersion) ObjectID() string {
+	return o.ID
+}
+
+// CreatedAt ...
+func (o ParseRecipeVersion) CreatedAt() time.Time {
+	return o.Created
+}
+
+// SetID ...
+func (o ParseRecipeVersion) SetID(id string) parse.Object {
+	o.ID = id
+	return o
+}
+
+// SetClass ...
+func (o ParseRecipeVersion) SetClass(class string) parse.Object {
+	o.Class = class
+	return o
+}
+
+// JSON ...
+func (o ParseRecipeVersion) JSON() (string, error) {
+	j, err := json.Marshal(o)
+	return string(j), err
+}
+
+// RecipeVersionSlice is a recipe's history sorted by version.
+type RecipeVersionSlice []ParseRecipeVersion
+
+func (s RecipeVersionSlice) Len() int           { return len(s) }
+func (s RecipeVersionSlice) Less(i, j int) bool { return s[i].Version < s[j].Version }
+func (s RecipeVersionSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jesusrmoreno/parse"
+)
+
+// RunCounts are the counts kept by a scrape run report.
+type RunCounts struct {
+	Menus   int `json:"menus"`
+	Meals   int `json:"meals"`
+	Recipes int `json:"recipes"`
+	// NewRecipes, UpdatedRecipes and DuplicateRecipes are only counted when
+	// the run saves to parse.
+	NewRecipes       int `json:"newRecipes"`
+	UpdatedRecipes   int `json:"updatedRecipes"`
+	DuplicateRecipes int `json:"duplicateRecipes"`
+	Offerings        int `json:"offerings"`
+	NutrientFailures int `json:"nutrientFailures"`
+	// RPCErrors are the calls for the venue that CWP answered with an error.
+	RPCErrors int `json:"rpcErrors"`
+	// NotificationsSkipped are the notifications that weren't created because
+	// we already had them.
+	NotificationsCreated int `json:"notificationsCreated"`
+	NotificationsSkipped int `json:"notificationsSkipped"`
+	NotificationsDeleted int `json:"notificationsDeleted"`
+}
+
+// Add adds the counts in o to c.
+func (c *RunCounts) Add(o RunCounts) {
+	c.Menus += o.Menus
+	c.Meals += o.Meals
+	c.Recipes += o.Recipes
+	c.NewRecipes += o.NewRecipes
+	c.UpdatedRecipes += o.UpdatedRecipes
+	c.DuplicateRecipes += o.DuplicateRecipes
+	c.Offerings += o.Offerings
+	c.NutrientFailures += o.NutrientFailures
+	c.RPCErrors += o.RPCErrors
+	c.NotificationsCreated += o.NotificationsCreated
+	c.NotificationsSkipped += o.NotificationsSkipped
+	c.NotificationsDeleted += o.NotificationsDeleted
+}
+
+// VenueRun is what a run did for a venue on a date. Date looks like
+// 2006-01-02 so that the venues sort by it.
+type VenueRun struct {
+	Date  string `json:"date"`
+	Venue string `json:"venue"`
+	Name  string `json:"name"`
+	// Error is set when the venue couldn't be scraped at all and Failed when
+	// some of its requests failed.
+	Error  string `json:"error,omitempty"`
+	Failed bool   `json:"failed,omitempty"`
+	// Seconds is how long the venue took to scrape, it is 0 for the venues
+	// that only show up because notifications for them were deleted.
+	Seconds float64 `json:"seconds"`
+	RunCounts
+}
+
+// ParseScrapeRun is the report of a scrape run. It is written to disk at the
+// end of every run and can be stored in parse to see trends over time.
+type ParseScrapeRun struct {
+	ID       string     `json:"objectId"`
+	Class    string     `json:"-"`
+	Created  time.Time  `json:"createdAt"`
+	RunID    string     `json:"runId"`
+	Site     string     `json:"site"`
+	Started  DateObject `json:"started"`
+	Finished DateObject `json:"finished"`
+	Seconds  float64    `json:"seconds"`
+	// Phases is how many seconds each part of the run took eg: scrape, prune
+	Phases map[string]float64 `json:"phases"`
+	// Error is set when the run stopped early.
+	Error  string     `json:"error,omitempty"`
+	Totals RunCounts  `json:"totals"`
+	Venues []VenueRun `json:"venues"`
+	// Drift are the parts of the CWP responses that didn't match the schema
+	// during the run.
+	Drift []SchemaDrift `json:"drift,omitempty"`
+}
+
+// SchemaDrift is a part of the CWP responses that stopped looking like we
+// expect eg: the name of a menu being a number. Field is where in any of the
+// items eg: menus_list[*][3], Count is how many items were skipped because of
+// it and Example is the start of the first one.
+type SchemaDrift struct {
+	Method  string    `json:"method"`
+	Field   string    `json:"field"`
+	Want    string    `json:"want"`
+	Got     string    `json:"got"`
+	Count   int       `json:"count"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+	Example string    `json:"example"`
+}
+
+// NewScrapeRun returns an empty report for the run started at start.
+func NewScrapeRun(runID string, start time.Time) *ParseScrapeRun {
+	return &ParseScrapeRun{
+		Class:   "ScrapeRun",
+		RunID:   runID,
+		Started: DateObject{Type: "Date", ISO: start},
+		Phases:  map[string]float64{},
+		Venues:  []VenueRun{},
+	}
+}
+
+// Venue returns the entry of the venue on the date adding it if it is new.
+func (o *ParseScrapeRun) Venue(date, venue string) *VenueRun {
+	for i := range o.Venues {
+		if o.Venues[i].Date == date && o.Venues[i].Venue == venue {
+			return &o.Venues[i]
+		}
+	}
+	o.Venues = append(o.Venues, VenueRun{Date: date, Venue: venue})
+	return &o.Venues[len(o.Venues)-1]
+}
+
+// Finish sorts the venues, adds up the totals and records when the run ended.
+func (o *ParseScrapeRun) Finish(end time.Time) {
+	sort.SliceStable(o.Venues, func(i, j int) bool {
+		if o.Venues[i].Date != o.Venues[j].Date {
+			return o.Venues[i].Date < o.Venues[j].Date
+		}
+		return o.Venues[i].Venue < o.Venues[j].Venue
+	})
+	o.Totals = RunCounts{}
+	for _, v := range o.Venues {
+		o.Totals.Add(v.RunCounts)
+	}
+	o.Finished = DateObject{Type: "Date", ISO: end}
+	o.Seconds = end.Sub(o.Started.ISO).Seconds()
+}
+
+// ClassName ...
+func (o ParseScrapeRun) ClassName() string {
+	return o.Class
+}
+
+// ObjectID ...
+func (o ParseScrapeRun) ObjectID() string {
+	return o.ID
+}
+
+// CreatedAt ...
+func (o ParseScrapeRun) CreatedAt() time.Time {
+	return o.Created
+}
+
+// SetID ...
+func (o ParseScrapeRun) SetID(id string) parse.Object {
+	o.ID = id
+	return o
+}
+
+// SetClass ...
+func (o ParseScrapeRun) SetClass(class string) parse.Object {
+	o.Class = class
+	return o
+}
+
+// JSON ...
+func (o ParseScrapeRun) JSON() (string, error) {
+	j, err := json.Marshal(o)
+	return string(j), err
+}
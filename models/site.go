@@ -0,0 +1,47 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultSite is the site everything was scraped from before we supported
+// more than one. Records stored before then have no site and belong to it.
+const DefaultSite = "dartmouth"
+
+// SiteOf returns the site a record tagged with site belongs to.
+func SiteOf(site string) string {
+	if site == "" {
+		return DefaultSite
+	}
+	return site
+}
+
+// siteLocations are the timezones of the sites other than the default one.
+var siteLocations = struct {
+	sync.RWMutex
+	m map[string]*time.Location
+}{m: map[string]*time.Location{}}
+
+// SetSiteLocation records the timezone the site's menus are in so that the
+// dates of its records are days in it.
+func SetSiteLocation(site string, loc *time.Location) {
+	siteLocations.Lock()
+	defer siteLocations.Unlock()
+	siteLocations.m[SiteOf(site)] = loc
+}
+
+// LocationOf returns the timezone of the site's menus. It is Location for the
+// default site and for sites whose timezone wasn't set.
+func LocationOf(site string) *time.Location {
+	site = SiteOf(site)
+	if site == DefaultSite {
+		return Location
+	}
+	siteLocations.RLock()
+	defer siteLocations.RUnlock()
+	if loc := siteLocations.m[site]; loc != nil {
+		return loc
+	}
+	return Location
+}
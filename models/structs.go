@@ -1,35 +1,78 @@
 package models
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
 
-// We're making the errors a string because if there is an error we probably
-// need a code rewrite anyway... No point in doing type assertions when all
-// we need to know is whether or not an error exists.
+// RPCError is the error field of a CWP response. It is null when the call
+// worked, otherwise CWP sends either a string or an object with a code and a
+// message so we accept both.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// UnmarshalJSON reads the error whatever its shape. Objects that have neither
+// a code nor a message keep their JSON as the message so the error isn't lost.
+func (e *RPCError) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	*e = RPCError{}
+	switch {
+	case len(b) == 0 || bytes.Equal(b, []byte("null")):
+		return nil
+	case b[0] == '"':
+		return json.Unmarshal(b, &e.Message)
+	case b[0] == '{':
+		type plain RPCError
+		p := plain{}
+		if err := json.Unmarshal(b, &p); err != nil {
+			return err
+		}
+		*e = RPCError(p)
+		fields := map[string]json.RawMessage{}
+		json.Unmarshal(b, &fields)
+		_, code := fields["code"]
+		_, message := fields["message"]
+		if e.Empty() && len(fields) > 0 && !code && !message {
+			e.Message = string(b)
+		}
+		return nil
+	}
+	e.Message = string(b)
+	return nil
+}
+
+// Empty is true if there was no error.
+func (e RPCError) Empty() bool {
+	return e.Code == 0 && e.Message == ""
+}
 
 // AvailableSIDSResponse is the structure of the JSON we're expecting to get
 // back when we query for the AvailableSIDs ie: DDS, NOVACK, etc..
 type AvailableSIDSResponse struct {
-	Error  string `json:"error"`
-	ID     int    `json:"id"`
+	Error  RPCError `json:"error"`
+	ID     int      `json:"id"`
 	Result struct {
-		CWPVersion string     `json:"cwp_version"`
-		Result     [][]string `json:"result"`
+		CWPVersion string        `json:"cwp_version"`
+		Result     []interface{} `json:"result"`
 	} `json:"result"`
 }
 
 // MenuListResponse ...
 type MenuListResponse struct {
-	Error  string `json:"error"`
-	ID     int    `json:"id"`
+	Error  RPCError `json:"error"`
+	ID     int      `json:"id"`
 	Result struct {
-		MenusList [][]interface{} `json:"menus_list"`
+		MenusList []interface{} `json:"menus_list"`
 	} `json:"result"`
 }
 
 // SIDResponse ...
 type SIDResponse struct {
-	Error  string `json:"error"`
-	ID     int    `json:"id"`
+	Error  RPCError `json:"error"`
+	ID     int      `json:"id"`
 	Result struct {
 		Sid string `json:"sid"`
 	} `json:"result"`
@@ -37,8 +80,8 @@ type SIDResponse struct {
 
 // MealsListResponse ...
 type MealsListResponse struct {
-	Error  string `json:"error"`
-	ID     int    `json:"id"`
+	Error  RPCError `json:"error"`
+	ID     int      `json:"id"`
 	Result struct {
 		MealsList map[string]interface{} `json:"meals_list"`
 	} `json:"result"`
@@ -46,17 +89,18 @@ type MealsListResponse struct {
 
 // RecipeResponse ..
 type RecipeResponse struct {
-	Error  string `json:"error"`
-	ID     int    `json:"id"`
+	Error  RPCError `json:"error"`
+	ID     int      `json:"id"`
 	Result struct {
-		MmID            int             `json:"mm_id"`
-		RecipeitemsList [][]interface{} `json:"recipeitems_list"`
-		CatList         [][]string      `json:"cat_list"`
+		MmID            int           `json:"mm_id"`
+		RecipeitemsList []interface{} `json:"recipeitems_list"`
+		CatList         [][]string    `json:"cat_list"`
 	} `json:"result"`
 }
 
 // VenueInfo ...
 type VenueInfo struct {
+	Site      string          `json:"site"`
 	Date      time.Time       `json:"date"`
 	SID       string          `json:"sid"`
 	Venue     string          `json:"venue"`
@@ -71,6 +115,18 @@ type VenueInfo struct {
 type MenuMeal struct {
 	Meal  MealInfo      `json:"meal"`
 	Menus MenuInfoSlice `json:"menus"`
+	// Partial are the ids of the menus we couldn't read every recipe of.
+	Partial []int `json:"partial,omitempty"`
+}
+
+// IsPartial is true if we couldn't read every recipe of the menu.
+func (mm MenuMeal) IsPartial(menu int) bool {
+	for _, id := range mm.Partial {
+		if id == menu {
+			return true
+		}
+	}
+	return false
 }
 
 // MenuMealSlice ...
@@ -85,6 +141,16 @@ type MenuInfo struct {
 // MenuInfoSlice ...
 type MenuInfoSlice []MenuInfo
 
+// Name returns the name of the menu with the id or "" if there isn't one.
+func (ms MenuInfoSlice) Name(id int) string {
+	for _, m := range ms {
+		if m.ID == id {
+			return m.Name
+		}
+	}
+	return ""
+}
+
 // MealInfo ...
 type MealInfo struct {
 	ID        int    `json:"did"`
@@ -97,6 +163,16 @@ type MealInfo struct {
 // MealInfoSlice ...
 type MealInfoSlice []MealInfo
 
+// Name returns the name of the meal with the id or "" if there isn't one.
+func (ms MealInfoSlice) Name(id int) string {
+	for _, m := range ms {
+		if m.ID == id {
+			return m.Name
+		}
+	}
+	return ""
+}
+
 // RecipeInfo ...
 type RecipeInfo struct {
 	Name      string               `json:"name"`
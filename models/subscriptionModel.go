@@ -12,6 +12,9 @@ type Subscription struct {
 	CreatedAt time.Time `json:"createdAt"`
 	ObjectID  string    `json:"objectId"`
 	Recipes   []int     `json:"recipes"`
+	// Rules are written in the rules package's language eg:
+	// vegan and venue = CYC
+	Rules     []string  `json:"rules"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jesusrmoreno/parse"
+)
+
+// ParseUser is the part of a parse _User we need to reach them.
+type ParseUser struct {
+	ID       string    `json:"objectId"`
+	Class    string    `json:"-"`
+	Created  time.Time `json:"createdAt"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	// Channels are how the user wants to be notified, users that haven't
+	// picked any get emailed.
+	Channels []ChannelPreference `json:"notificationChannels"`
+	// Timezone is the user's IANA timezone, the quiet hours and delivery window
+	// are local times in it. It defaults to Location.
+	Timezone string `json:"timezone"`
+	// QuietHours is when the user doesn't want to be notified eg: 22:00-07:00
+	QuietHours string `json:"quietHours"`
+	// DeliveryWindow is when the user wants to be notified eg: 07:00-21:00,
+	// any time is fine if it is empty.
+	DeliveryWindow string `json:"deliveryWindow"`
+}
+
+// Local returns t in the user's timezone.
+func (o ParseUser) Local(t time.Time) time.Time {
+	if o.Timezone == "" {
+		return t.In(Location)
+	}
+	loc, err := time.LoadLocation(o.Timezone)
+	if err != nil {
+		return t.In(Location)
+	}
+	return t.In(loc)
+}
+
+// CanNotify is true if the user wants to be notified at t, which is when it is
+// inside their delivery window and outside of their quiet hours. Ranges that
+// can't be parsed are returned as an error and ignored.
+func (o ParseUser) CanNotify(t time.Time) (bool, error) {
+	local := o.Local(t)
+	if o.DeliveryWindow != "" {
+		window, err := ParseClockRange(o.DeliveryWindow)
+		if err != nil {
+			return true, err
+		}
+		if !window.Contains(local) {
+			return false, nil
+		}
+	}
+	if o.QuietHours != "" {
+		quiet, err := ParseClockRange(o.QuietHours)
+		if err != nil {
+			return true, err
+		}
+		if quiet.Contains(local) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// The channel types a user can pick.
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelSlack   = "slack"
+	ChannelDiscord = "discord"
+)
+
+// ChannelPreference is one of the ways a user wants to be notified.
+type ChannelPreference struct {
+	Type string `json:"type"`
+	// URL is where webhook, Slack and Discord messages are POSTed.
+	URL string `json:"url,omitempty"`
+	// Secret signs the generic webhook payloads.
+	Secret string `json:"secret,omitempty"`
+	// Address overrides the user's email address for the email channel.
+	Address string `json:"address,omitempty"`
+}
+
+// Preferences returns the user's channels defaulting to email.
+func (o ParseUser) Preferences() []ChannelPreference {
+	if len(o.Channels) == 0 {
+		return []ChannelPreference{{Type: ChannelEmail}}
+	}
+	return o.Channels
+}
+
+// ClassName ...
+func (o ParseUser) ClassName() string {
+	return o.Class
+}
+
+// ObjectID ...
+func (o ParseUser) ObjectID() string {
+	return o.ID
+}
+
+// CreatedAt ...
+func (o ParseUser) CreatedAt() time.Time {
+	return o.Created
+}
+
+// SetID ...
+func (o ParseUser) SetID(id string) parse.Object {
+	o.ID = id
+	return o
+}
+
+// SetClass ...
+func (o ParseUser) SetClass(class string) parse.Object {
+	o.Class = class
+	return o
+}
+
+// JSON ...
+func (o ParseUser) JSON() (string, error) {
+	j, err := json.Marshal(o)
+	return string(j), err
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+	"github.com/jesusrmoreno/parse"
+)
+
+// dedupeNotifications returns the notifications that aren't stored yet, each
+// one only once, along with how many were skipped as duplicates.
+func dedupeNotifications(stored map[string]models.ParseNotification, ns []models.ParseNotification) ([]models.ParseNotification, int) {
+	toPost := []models.ParseNotification{}
+	seen := map[string]bool{}
+	skipped := 0
+	for _, n := range ns {
+		if stored[n.UUID].UUID == n.UUID || seen[n.UUID] {
+			skipped++
+			continue
+		}
+		seen[n.UUID] = true
+		toPost = append(toPost, n)
+	}
+	return toPost, skipped
+}
+
+// planUUIDMigration works out which stored notifications need their uuid
+// changed to the one GenerateUUID gives now and which ones are duplicates that
+// should be deleted. When several notifications end up with the same uuid the
+// one that already has it is kept, otherwise the first one is.
+func planUUIDMigration(ns []models.ParseNotification) (update, remove []models.ParseNotification) {
+	sorted := make([]models.ParseNotification, len(ns))
+	copy(sorted, ns)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		iCurrent := sorted[i].UUID == sorted[i].GenerateUUID()
+		jCurrent := sorted[j].UUID == sorted[j].GenerateUUID()
+		return iCurrent && !jCurrent
+	})
+
+	kept := map[string]bool{}
+	for _, n := range sorted {
+		uuid := n.GenerateUUID()
+		if kept[uuid] {
+			remove = append(remove, n)
+			continue
+		}
+		kept[uuid] = true
+		if n.UUID != uuid {
+			n.UUID = uuid
+			update = append(update, n)
+		}
+	}
+	return update, remove
+}
+
+// migrateNotificationUUIDs gives every stored notification the uuid that
+// GenerateUUID gives now and deletes the ones that turn out to be duplicates.
+func migrateNotificationUUIDs(c *cli.Context) {
+	s := newState()
+	ns := getNotificationsFromParse(&s, 1000, "")
+	update, remove := planUUIDMigration(ns)
+	s.Log.WithFields(logrus.Fields{
+		"Stored": len(ns),
+		"Update": len(update),
+		"Delete": len(remove),
+	}).Info("Notification UUID Migration")
+	if c.Bool("dry-run") {
+		return
+	}
+
+	updated, deleted := 0, 0
+	for _, n := range update {
+		x := struct {
+			UUID string `json:"uuid"`
+		}{
+			UUID: n.UUID,
+		}
+		_, status, errs := s.DB.Put(x, "Notification", n.ObjectID())
+		if errs != nil || status == 400 {
+			s.Log.Error(status)
+			s.Log.Error(errors.Errorf("Unable to update Notification with objectId: %s", n.ObjectID()))
+			continue
+		}
+		updated++
+	}
+	for _, n := range remove {
+		status, errs := s.DB.Delete(parse.Params{
+			Class:    "Notification",
+			ObjectID: n.ObjectID(),
+		}, nil)
+		if errs != nil || status == 400 {
+			s.Log.Error(status)
+			s.Log.Error(errors.Errorf("Unable to delete Notification with objectId: %s", n.ObjectID()))
+			continue
+		}
+		deleted++
+	}
+	s.Log.WithFields(logrus.Fields{
+		"Updated": updated,
+		"Deleted": deleted,
+	}).Info("Migrated Notifications")
+}
+
+// notificationsMu guards s.Notifications while the server is marking
+// notifications as seen or dismissed.
+var notificationsMu sync.RWMutex
+
+// throttle calls fn with every index below n, running at most limit calls at
+// a time, and returns once all of them are done.
+func throttle(limit, n int, fn func(i int)) {
+	sem := make(chan bool, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- true
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// findNotification looks up a notification by its uuid or parse objectId.
+func findNotification(s *State, id string) (models.ParseNotification, error) {
+	notificationsMu.RLock()
+	defer notificationsMu.RUnlock()
+	if n, ok := s.Notifications[id]; ok {
+		return n, nil
+	}
+	for _, n := range s.Notifications {
+		if n.ObjectID() == id {
+			return n, nil
+		}
+	}
+	return models.ParseNotification{}, errors.Errorf("No notification with id: %s", id)
+}
+
+// markNotification moves the notification to the seen or dismissed state and
+// returns it as stored.
+func markNotification(s *State, n models.ParseNotification, state string, now time.Time) (models.ParseNotification, error) {
+	at := &models.DateObject{Type: "Date", ISO: now}
+	update := map[string]interface{}{}
+	switch state {
+	case models.NotificationSeen:
+		n.Seen, n.SeenAt = true, at
+		update["seen"], update["seenAt"] = n.Seen, n.SeenAt
+	case models.NotificationDismissed:
+		n.Dismissed, n.DismissedAt = true, at
+		update["dismissed"], update["dismissedAt"] = n.Dismissed, n.DismissedAt
+	default:
+		return n, errors.Errorf("Notifications can't be marked as %s", state)
+	}
+	_, status, errs := s.DB.Put(update, "Notification", n.ObjectID())
+	if errs != nil || status == 400 {
+		return n, errors.Errorf("Unable to mark Notification %s as %s, status: %d", n.UUID, state, status)
+	}
+	notificationsMu.Lock()
+	s.Notifications[n.UUID] = n
+	notificationsMu.Unlock()
+	return n, nil
+}
+
+// markNotifications is the action for the seen and dismiss commands, the
+// notifications are given as uuids or objectIds.
+func markNotifications(state string) func(c *cli.Context) {
+	return func(c *cli.Context) {
+		if len(c.Args()) == 0 {
+			log.Fatal("Expected at least one notification id")
+		}
+		s := newState()
+		for _, n := range getNotificationsFromParse(&s, 1000, "") {
+			s.Notifications[n.UUID] = n
+		}
+		for _, id := range c.Args() {
+			n, err := findNotification(&s, id)
+			if err != nil {
+				s.Log.Error(err)
+				continue
+			}
+			if _, err := markNotification(&s, n, state, time.Now()); err != nil {
+				s.Log.Error(err)
+				continue
+			}
+			s.Log.WithFields(logrus.Fields{
+				"uuid":  n.UUID,
+				"state": state,
+			}).Info("Marked Notification")
+		}
+	}
+}
+
+// notificationRetention is how long notifications and digests are kept, by
+// state, after they expire.
+type notificationRetention map[string]time.Duration
+
+// retentionFlags configure the retention for both the scrape and the prune
+// command. By default notifications and digests are pruned as soon as they
+// expire.
+var retentionFlags = []cli.Flag{
+	cli.DurationFlag{
+		Name:  "keep-unseen",
+		Usage: "How long to keep unseen notifications and digests after they expire",
+	},
+	cli.DurationFlag{
+		Name:  "keep-seen",
+		Usage: "How long to keep seen notifications and digests after they expire",
+	},
+	cli.DurationFlag{
+		Name:  "keep-dismissed",
+		Usage: "How long to keep dismissed notifications after they expire",
+	},
+}
+
+// retentionFromContext reads the retention from the retentionFlags.
+func retentionFromContext(c *cli.Context) notificationRetention {
+	return notificationRetention{
+		models.NotificationUnseen:    c.Duration("keep-unseen"),
+		models.NotificationSeen:      c.Duration("keep-seen"),
+		models.NotificationDismissed: c.Duration("keep-dismissed"),
+	}
+}
+
+// expiredNotifications returns the notifications that have been expired for
+// longer than their state's retention.
+func expiredNotifications(ns []models.ParseNotification, retention notificationRetention, now time.Time) []models.ParseNotification {
+	expired := []models.ParseNotification{}
+	for _, n := range ns {
+		if !now.Before(n.ExpiresAt().Add(retention[n.State()])) {
+			expired = append(expired, n)
+		}
+	}
+	return expired
+}
+
+// expiredDigests returns the digests that have been expired for longer than
+// their state's retention.
+func expiredDigests(ds []models.ParseDigest, retention notificationRetention, now time.Time) []models.ParseDigest {
+	expired := []models.ParseDigest{}
+	for _, d := range ds {
+		if !now.Before(d.ExpiresAt().Add(retention[d.State()])) {
+			expired = append(expired, d)
+		}
+	}
+	return expired
+}
+
+// pruneReport describes what a prune deleted.
+type pruneReport struct {
+	// Class is the parse class that was pruned.
+	Class string
+	// Deleted counts the deleted objects by state.
+	Deleted map[string]int
+	UUIDs   []string
+	Failed  int
+}
+
+// Log writes the report to the log.
+func (r pruneReport) Log(l *logrus.Entry) {
+	l.WithFields(logrus.Fields{
+		models.NotificationUnseen:    r.Deleted[models.NotificationUnseen],
+		models.NotificationSeen:      r.Deleted[models.NotificationSeen],
+		models.NotificationDismissed: r.Deleted[models.NotificationDismissed],
+		"failed":                     r.Failed,
+	}).Info("Pruned " + r.Class + "s")
+	for _, uuid := range r.UUIDs {
+		l.WithField("uuid", uuid).Debug("Pruned " + r.Class)
+	}
+}
+
+// prunable is a stored object a prune deletes.
+type prunable struct {
+	objectID string
+	uuid     string
+	state    string
+}
+
+// deletePrunable deletes the objects of the class from parse and reports
+// them by state. With dryRun nothing is deleted but the report is the same.
+func deletePrunable(s *State, class string, ps []prunable, dryRun bool) pruneReport {
+	report := pruneReport{Class: class, Deleted: map[string]int{}, UUIDs: []string{}}
+	var mu sync.Mutex
+	throttle(20, len(ps), func(i int) {
+		p := ps[i]
+		if !dryRun {
+			status, errs := s.DB.Delete(parse.Params{
+				Class:    class,
+				ObjectID: p.objectID,
+			}, nil)
+			if errs != nil || status == 400 {
+				s.Log.WithField("status", status).Error(errors.Errorf("Unable to delete %s with ID: %s", class, p.uuid))
+				mu.Lock()
+				report.Failed++
+				mu.Unlock()
+				return
+			}
+		}
+		mu.Lock()
+		report.Deleted[p.state]++
+		report.UUIDs = append(report.UUIDs, p.uuid)
+		mu.Unlock()
+	})
+	sort.Strings(report.UUIDs)
+	return report
+}
+
+// pruneNotifications deletes the notifications that are past their retention
+// from parse and from s.Notifications. With dryRun nothing is deleted but the
+// report still says what would have been.
+func pruneNotifications(s *State, ns []models.ParseNotification, retention notificationRetention, now time.Time, dryRun bool) pruneReport {
+	ps := []prunable{}
+	for _, n := range expiredNotifications(ns, retention, now) {
+		ps = append(ps, prunable{objectID: n.ObjectID(), uuid: n.UUID, state: n.State()})
+	}
+	report := deletePrunable(s, "Notification", ps, dryRun)
+	if !dryRun {
+		notificationsMu.Lock()
+		for _, uuid := range report.UUIDs {
+			delete(s.Notifications, uuid)
+		}
+		notificationsMu.Unlock()
+	}
+	return report
+}
+
+// pruneDigests deletes the digests that are past their retention from parse
+// and from s.Digests. With dryRun nothing is deleted but the report is the
+// same.
+func pruneDigests(s *State, ds []models.ParseDigest, retention notificationRetention, now time.Time, dryRun bool) pruneReport {
+	ps := []prunable{}
+	for _, d := range expiredDigests(ds, retention, now) {
+		ps = append(ps, prunable{objectID: d.ObjectID(), uuid: d.UUID, state: d.State()})
+	}
+	report := deletePrunable(s, "Digest", ps, dryRun)
+	if !dryRun {
+		for _, uuid := range report.UUIDs {
+			delete(s.Digests, uuid)
+		}
+	}
+	return report
+}
+
+// pruneCommand deletes the stored notifications and digests of the --site
+// sites that are past their retention.
+func pruneCommand(c *cli.Context) {
+	sites, err := sitesFromContext(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, st := range sites {
+		s := newState()
+		useSite(&s, st)
+		ns := getNotificationsFromParse(&s, 1000, siteWhere(&s))
+		pruneNotifications(&s, ns, retentionFromContext(c), time.Now(), c.Bool("dry-run")).Log(s.Log)
+		ds := getDigestsFromParse(&s, 1000, siteWhere(&s))
+		pruneDigests(&s, ds, retentionFromContext(c), time.Now(), c.Bool("dry-run")).Log(s.Log)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// notification returns a notification for the user and recipe on the 1st of
+// November 2016 with its current uuid.
+func notification(user string, recipe int) models.ParseNotification {
+	n := models.ParseNotification{
+		RecipeID: recipe,
+		Name:     "Soup",
+		Day:      1,
+		Month:    11,
+		Year:     2016,
+		For:      models.CreatedBy{Kind: "Pointer", ClassName: "_User", ObjectID: user},
+		MenuName: "Lunch Specials",
+		MealName: "Lunch",
+		Venue:    "DDS",
+	}
+	n.UUID = n.GenerateUUID()
+	return n
+}
+
+func TestDedupeNotifications(t *testing.T) {
+	a, b, c := notification("u1", 1), notification("u1", 2), notification("u2", 1)
+	stored := map[string]models.ParseNotification{a.UUID: a}
+
+	toPost, skipped := dedupeNotifications(stored, []models.ParseNotification{a, b, c, b})
+	if skipped != 2 {
+		t.Errorf("Expected 2 skipped, got %d", skipped)
+	}
+	if len(toPost) != 2 || toPost[0].UUID != b.UUID || toPost[1].UUID != c.UUID {
+		t.Errorf("Expected the second and third notifications, got %v", toPost)
+	}
+
+	toPost, skipped = dedupeNotifications(stored, nil)
+	if len(toPost) != 0 || skipped != 0 {
+		t.Errorf("Expected nothing for no notifications, got %v and %d", toPost, skipped)
+	}
+}
+
+func TestPlanUUIDMigration(t *testing.T) {
+	current := notification("u1", 1)
+	current.ID = "current"
+	// The same notification stored before the uuid changed is a duplicate of
+	// current, it is deleted even though it comes first.
+	old := current
+	old.ID = "old"
+	old.UUID = "old-uuid"
+	// Two old copies of a notification that isn't stored with the current
+	// uuid, the first one is kept and updated.
+	first := notification("u1", 2)
+	first.ID = "first"
+	first.UUID = "old-uuid-2"
+	second := first
+	second.ID = "second"
+	// A notification for another site gets the site in its uuid.
+	other := notification("u1", 3)
+	other.ID = "other"
+	other.Site = "other-site"
+
+	update, remove := planUUIDMigration([]models.ParseNotification{old, current, first, second, other})
+
+	ids := func(ns []models.ParseNotification) []string {
+		out := []string{}
+		for _, n := range ns {
+			out = append(out, n.ID)
+		}
+		return out
+	}
+	if got := ids(update); len(got) != 2 || got[0] != "first" || got[1] != "other" {
+		t.Errorf("Expected first and other to be updated, got %v", got)
+	}
+	for _, n := range update {
+		if n.UUID != n.GenerateUUID() {
+			t.Errorf("Expected %s to get its current uuid, got %s", n.ID, n.UUID)
+		}
+	}
+	if got := ids(remove); len(got) != 2 || got[0] != "old" || got[1] != "second" {
+		t.Errorf("Expected old and second to be deleted, got %v", got)
+	}
+
+	update, remove = planUUIDMigration([]models.ParseNotification{current})
+	if len(update) != 0 || len(remove) != 0 {
+		t.Errorf("Expected nothing to do for current uuids, got %v and %v", update, remove)
+	}
+}
+
+func TestExpiredDigests(t *testing.T) {
+	now := time.Date(2016, time.November, 3, 12, 0, 0, 0, models.Location)
+	digest := func(day int, seen bool) models.ParseDigest {
+		return models.ParseDigest{UUID: fmt.Sprint(day, seen), Day: day, Month: 11, Year: 2016, Seen: seen}
+	}
+	ds := []models.ParseDigest{
+		digest(1, false),
+		digest(1, true),
+		digest(2, false),
+		digest(2, true),
+		// today's digests haven't expired
+		digest(3, false),
+	}
+	retention := notificationRetention{models.NotificationSeen: 36 * time.Hour}
+	expired := expiredDigests(ds, retention, now)
+	got := []string{}
+	for _, d := range expired {
+		got = append(got, d.UUID)
+	}
+	if len(got) != 3 || got[0] != "1 false" || got[1] != "1 true" || got[2] != "2 false" {
+		t.Errorf("Expected the unseen digests before today and the seen one kept for 36h to expire, got %v", got)
+	}
+}
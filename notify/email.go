@@ -0,0 +1,188 @@
+// Package notify delivers the notifications created during a scrape to the
+// users they are for over the channels they picked.
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// Item is a single subscribed recipe being served.
+type Item struct {
+	RecipeID int
+	Recipe   string
+	Venue    string
+	Meal     string
+	Menu     string
+	Date     time.Time
+}
+
+// Message is everything we have to tell a user after a scrape.
+type Message struct {
+	User  models.ParseUser
+	Items []Item
+}
+
+// NewMessage builds the message for the user from their notifications. venues
+// maps the venue keys to display names, keys without a name are used as is.
+func NewMessage(u models.ParseUser, ns []models.ParseNotification, venues map[string]string) Message {
+	m := Message{User: u}
+	for _, n := range ns {
+		venue := venues[n.Venue]
+		if venue == "" {
+			venue = n.Venue
+		}
+		m.Items = append(m.Items, Item{
+			RecipeID: n.RecipeID,
+			Recipe:   n.Name,
+			Venue:    venue,
+			Meal:     n.MealName,
+			Menu:     n.MenuName,
+			Date:     n.Date(),
+		})
+	}
+	sort.SliceStable(m.Items, func(i, j int) bool {
+		a, b := m.Items[i], m.Items[j]
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		if a.Venue != b.Venue {
+			return a.Venue < b.Venue
+		}
+		return a.Meal < b.Meal
+	})
+	return m
+}
+
+// Channel is a way of delivering messages to users eg: email or Slack.
+type Channel interface {
+	// Type is the channel type users pick in their preferences.
+	Type() string
+	// Send delivers the message using the user's preference for the channel.
+	Send(m Message, p models.ChannelPreference) error
+}
+
+// DefaultTemplate is used for the emails unless another one is loaded. A
+// template has to define both a "subject" and a "body" template.
+const DefaultTemplate = `{{define "subject"}}{{with index .Items 0}}{{.Recipe}} is at {{.Venue}} for {{.Meal}} on {{.Date.Format "Monday"}}{{end}}{{if gt (len .Items) 1}} and {{more .Items}} more{{end}}{{end}}
+{{define "body"}}Hi {{if .User.Username}}{{.User.Username}}{{else}}there{{end}},
+
+Your favourite recipes are on the menu:
+{{range .Items}}
+  {{.Recipe}} is at {{.Venue}} for {{.Meal}} on {{.Date.Format "Monday, January 2"}} ({{.Menu}})
+{{- end}}
+
+Enjoy!
+{{end}}`
+
+var funcs = template.FuncMap{
+	// more is how many items there are after the first
+	"more": func(items []Item) int { return len(items) - 1 },
+}
+
+// ParseTemplate parses an email template, see DefaultTemplate.
+func ParseTemplate(text string) (*template.Template, error) {
+	t, err := template.New("email").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, 1)
+	}
+	if t.Lookup("subject") == nil || t.Lookup("body") == nil {
+		return nil, errors.Errorf("Email template must define subject and body")
+	}
+	return t, nil
+}
+
+// Email sends messages over SMTP. Leave Username empty for servers that don't
+// need authentication, like a local SMTP sink used for testing.
+type Email struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is shown with its name in the From header, only its address is
+	// sent as the envelope sender.
+	From     *mail.Address
+	Template *template.Template
+}
+
+// Render returns the subject and body of the email for the message.
+func (e *Email) Render(m Message) (string, string, error) {
+	t := e.Template
+	if t == nil {
+		var err error
+		if t, err = ParseTemplate(DefaultTemplate); err != nil {
+			return "", "", err
+		}
+	}
+	subject, body := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := t.ExecuteTemplate(subject, "subject", m); err != nil {
+		return "", "", errors.Wrap(err, 1)
+	}
+	if err := t.ExecuteTemplate(body, "body", m); err != nil {
+		return "", "", errors.Wrap(err, 1)
+	}
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
+
+// headerValue puts v on a single line so it can't add headers of its own.
+func headerValue(v string) string {
+	return strings.Join(strings.FieldsFunc(v, func(r rune) bool {
+		return r == '\r' || r == '\n'
+	}), " ")
+}
+
+// Type is the email channel.
+func (e *Email) Type() string {
+	return models.ChannelEmail
+}
+
+// Send emails the message to the address in the preference or else to the
+// user's email.
+func (e *Email) Send(m Message, p models.ChannelPreference) error {
+	to := p.Address
+	if to == "" {
+		to = m.User.Email
+	}
+	if to == "" {
+		return errors.Errorf("User %s has no email address", m.User.ID)
+	}
+	if strings.ContainsAny(to, "\r\n") {
+		return errors.Errorf("User %s has an invalid email address", m.User.ID)
+	}
+	if len(m.Items) == 0 {
+		return nil
+	}
+	subject, body, err := e.Render(m)
+	if err != nil {
+		return err
+	}
+
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", e.From.String())
+	fmt.Fprintf(msg, "To: %s\r\n", to)
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(subject)))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", e.Host, e.Port)
+	if err := smtp.SendMail(addr, auth, e.From.Address, []string{to}, msg.Bytes()); err != nil {
+		return errors.Wrap(err, 1)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"bufio"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// received is what the sink got: the envelope sender and the message.
+type received struct {
+	MailFrom string
+	Data     string
+}
+
+// smtpSink accepts a single email on a local port and sends what it received
+// on the channel.
+func smtpSink(t *testing.T) (int, <-chan received) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	data := make(chan received, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		got := received{}
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				got.MailFrom = strings.TrimSpace(line)
+				reply("250 ok")
+			case strings.HasPrefix(cmd, "DATA"):
+				reply("354 go ahead")
+				msg := &strings.Builder{}
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					msg.WriteString(l)
+				}
+				got.Data = msg.String()
+				data <- got
+				reply("250 ok")
+			case strings.HasPrefix(cmd, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port, data
+}
+
+func TestEmailSend(t *testing.T) {
+	port, data := smtpSink(t)
+	tmpl, err := ParseTemplate(`{{define "subject"}}{{with index .Items 0}}{{.Recipe}}{{end}}{{end}}{{define "body"}}Hi{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := mail.ParseAddress("Dartmouth Nutrition <no-reply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Email{Host: "127.0.0.1", Port: port, From: from, Template: tmpl}
+	m := Message{
+		User:  models.ParseUser{Email: "user@example.com"},
+		Items: []Item{{Recipe: "Crème Brûlée\r\nBcc: someone@example.com", Date: time.Now()}},
+	}
+	if err := e.Send(m, models.ChannelPreference{}); err != nil {
+		t.Fatal(err)
+	}
+	var got received
+	select {
+	case got = <-data:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No email was received")
+	}
+	if want := "MAIL FROM:<no-reply@example.com>"; !strings.HasPrefix(got.MailFrom, want) {
+		t.Errorf("Expected the envelope sender %q, got %q", want, got.MailFrom)
+	}
+	msg := got.Data
+	if !strings.Contains(msg, "From: \"Dartmouth Nutrition\" <no-reply@example.com>\r\n") {
+		t.Errorf("Expected the name in the From header:\n%s", msg)
+	}
+	headers := strings.SplitN(msg, "\r\n\r\n", 2)[0]
+	for _, h := range strings.Split(headers, "\r\n") {
+		if strings.HasPrefix(h, "Bcc:") {
+			t.Errorf("The subject added a header: %q", h)
+		}
+	}
+	want := "Subject: =?utf-8?q?Cr=C3=A8me_Br=C3=BBl=C3=A9e_Bcc:_someone@example.com?=\r\n"
+	if !strings.Contains(msg, want) {
+		t.Errorf("Expected %q in:\n%s", want, msg)
+	}
+	if !strings.Contains(msg, "To: user@example.com\r\n") {
+		t.Errorf("Expected the user's address in:\n%s", msg)
+	}
+}
+
+func TestEmailSendInvalidAddress(t *testing.T) {
+	e := &Email{Host: "127.0.0.1", Port: 1, From: &mail.Address{Address: "menus@example.com"}}
+	m := Message{Items: []Item{{Recipe: "Soup", Date: time.Now()}}}
+	p := models.ChannelPreference{Address: "user@example.com\r\nBcc: someone@example.com"}
+	if err := e.Send(m, p); err == nil {
+		t.Error("Expected an error for an address with a line break")
+	}
+}
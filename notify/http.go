@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+	"github.com/jesusrmoreno/nutrition-scraper/poster"
+	"github.com/jesusrmoreno/nutrition-scraper/webhook"
+)
+
+// discordLimit is the most characters Discord accepts in a message.
+const discordLimit = 2000
+
+// Attempt is the record of a single try at POSTing a message.
+type Attempt struct {
+	Channel string `json:"channel"`
+	User    string `json:"user"`
+	poster.Attempt
+}
+
+// HTTP POSTs messages for the webhook, Slack and Discord channels. Failed
+// POSTs are retried and every attempt is written to the poster's log as an
+// Attempt.
+type HTTP struct {
+	Poster *poster.Poster
+}
+
+// NewHTTP returns an HTTP poster that records its attempts in log.
+func NewHTTP(retries int, log io.Writer) *HTTP {
+	return &HTTP{Poster: poster.New(retries, log)}
+}
+
+// send marshals v and POSTs it to the preference's URL. With a secret the
+// body that is sent is signed like the scrape event webhooks.
+func (h *HTTP) send(channel string, m Message, p models.ChannelPreference, v interface{}, secret string) error {
+	if p.URL == "" {
+		return errors.Errorf("User %s has no %s url", m.User.ID, channel)
+	}
+	if len(m.Items) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+	header := http.Header{}
+	if secret != "" {
+		header.Set(webhook.SignatureHeader, webhook.Sign(secret, body))
+	}
+	return h.Poster.Post(p.URL, body, header, func(a poster.Attempt) interface{} {
+		return Attempt{Channel: channel, User: m.User.ID, Attempt: a}
+	})
+}
+
+// Text formats the message as one line per item, bold wraps the recipe names
+// in the markup of the chat service.
+func Text(m Message, bold func(string) string) string {
+	lines := []string{"Your favourite recipes are on the menu:"}
+	for _, item := range m.Items {
+		lines = append(lines, fmt.Sprintf("• %s at %s for %s on %s (%s)",
+			bold(item.Recipe), item.Venue, item.Meal, item.Date.Format("Monday, January 2"), item.Menu))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// WebhookItem is an item in the generic webhook payload.
+type WebhookItem struct {
+	RecipeID int    `json:"recipeId"`
+	Recipe   string `json:"recipe"`
+	Venue    string `json:"venue"`
+	Meal     string `json:"meal"`
+	Menu     string `json:"menu"`
+	Date     string `json:"date"`
+}
+
+// WebhookPayload is the body POSTed to generic webhooks.
+type WebhookPayload struct {
+	User  string        `json:"user"`
+	Items []WebhookItem `json:"items"`
+	Text  string        `json:"text"`
+}
+
+// Webhook POSTs the message as a WebhookPayload, signed like the scrape event
+// webhooks when the preference has a secret.
+type Webhook struct {
+	HTTP *HTTP
+}
+
+// Type is the generic webhook channel.
+func (w Webhook) Type() string {
+	return models.ChannelWebhook
+}
+
+// Send POSTs the message as a WebhookPayload to the preference's URL, signed
+// with its secret if it has one.
+func (w Webhook) Send(m Message, p models.ChannelPreference) error {
+	payload := WebhookPayload{
+		User:  m.User.ID,
+		Items: []WebhookItem{},
+		Text:  Text(m, func(s string) string { return s }),
+	}
+	for _, item := range m.Items {
+		payload.Items = append(payload.Items, WebhookItem{
+			RecipeID: item.RecipeID,
+			Recipe:   item.Recipe,
+			Venue:    item.Venue,
+			Meal:     item.Meal,
+			Menu:     item.Menu,
+			Date:     item.Date.Format("2006-01-02"),
+		})
+	}
+	return w.HTTP.send(w.Type(), m, p, payload, p.Secret)
+}
+
+// Slack POSTs the message to a Slack incoming webhook.
+type Slack struct {
+	HTTP *HTTP
+}
+
+// Type is the Slack channel.
+func (sl Slack) Type() string {
+	return models.ChannelSlack
+}
+
+// Send POSTs the message to the preference's Slack incoming webhook with the
+// recipes in bold.
+func (sl Slack) Send(m Message, p models.ChannelPreference) error {
+	text := Text(m, func(s string) string { return "*" + s + "*" })
+	return sl.HTTP.send(sl.Type(), m, p, map[string]string{"text": text}, "")
+}
+
+// Discord POSTs the message to a Discord webhook, messages over Discord's
+// limit are cut short.
+type Discord struct {
+	HTTP *HTTP
+}
+
+// Type is the Discord channel.
+func (d Discord) Type() string {
+	return models.ChannelDiscord
+}
+
+// Send POSTs the message to the preference's Discord webhook with the recipes
+// in bold, cut short at Discord's limit.
+func (d Discord) Send(m Message, p models.ChannelPreference) error {
+	text := Text(m, func(s string) string { return "**" + s + "**" })
+	if runes := []rune(text); len(runes) > discordLimit {
+		text = string(runes[:discordLimit-1]) + "…"
+	}
+	return d.HTTP.send(d.Type(), m, p, map[string]string{"content": text}, "")
+}
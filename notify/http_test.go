@@ -0,0 +1,190 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+	"github.com/jesusrmoreno/nutrition-scraper/webhook"
+)
+
+// request is what the test server received.
+type request struct {
+	Header http.Header
+	Body   []byte
+}
+
+// endpoint answers with the statuses in order, then with 200, and keeps the
+// requests it got.
+type endpoint struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []request
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requests = append(e.requests, request{Header: r.Header, Body: body})
+	status := http.StatusOK
+	if len(e.statuses) > 0 {
+		status, e.statuses = e.statuses[0], e.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// testHTTP returns an HTTP poster that retries right away and the log its
+// attempts are written to.
+func testHTTP(retries int) (*HTTP, *bytes.Buffer) {
+	log := &bytes.Buffer{}
+	h := NewHTTP(retries, log)
+	h.Poster.Backoff = time.Millisecond
+	return h, log
+}
+
+func testMessage(items int) Message {
+	m := Message{User: models.ParseUser{ID: "u1"}}
+	for i := 0; i < items; i++ {
+		m.Items = append(m.Items, Item{
+			RecipeID: i + 1,
+			Recipe:   "Soup",
+			Venue:    "Foco",
+			Meal:     "Lunch",
+			Menu:     "Specials",
+			Date:     models.Date(2016, time.November, 1),
+		})
+	}
+	return m
+}
+
+func TestWebhookSend(t *testing.T) {
+	e := &endpoint{}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	h, log := testHTTP(0)
+
+	p := models.ChannelPreference{Type: models.ChannelWebhook, URL: srv.URL, Secret: "shh"}
+	if err := (Webhook{HTTP: h}).Send(testMessage(1), p); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(e.requests))
+	}
+	r := e.requests[0]
+	if got, want := r.Header.Get(webhook.SignatureHeader), webhook.Sign("shh", r.Body); got != want {
+		t.Errorf("Expected signature %q, got %q", want, got)
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected a json content type, got %q", ct)
+	}
+	payload := WebhookPayload{}
+	if err := json.Unmarshal(r.Body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.User != "u1" || len(payload.Items) != 1 || payload.Items[0].Date != "2016-11-01" {
+		t.Errorf("Unexpected payload %+v", payload)
+	}
+
+	a := Attempt{}
+	if err := json.Unmarshal(log.Bytes(), &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Channel != models.ChannelWebhook || a.User != "u1" || a.Status != 200 || a.URL != srv.URL {
+		t.Errorf("Unexpected attempt %+v", a)
+	}
+}
+
+func TestSlackSendRetries(t *testing.T) {
+	e := &endpoint{statuses: []int{500, 502}}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	h, log := testHTTP(2)
+
+	p := models.ChannelPreference{Type: models.ChannelSlack, URL: srv.URL}
+	if err := (Slack{HTTP: h}).Send(testMessage(1), p); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.requests) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(e.requests))
+	}
+	body := map[string]string{}
+	if err := json.Unmarshal(e.requests[2].Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body["text"], "*Soup* at Foco") {
+		t.Errorf("Expected the recipe in bold, got %q", body["text"])
+	}
+	if sig := e.requests[2].Header.Get(webhook.SignatureHeader); sig != "" {
+		t.Errorf("Expected Slack messages to be unsigned, got %q", sig)
+	}
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 attempts in the log, got %d", len(lines))
+	}
+	for i, want := range []int{500, 502, 200} {
+		a := Attempt{}
+		if err := json.Unmarshal([]byte(lines[i]), &a); err != nil {
+			t.Fatal(err)
+		}
+		if a.Attempt.Attempt != i+1 || a.Status != want {
+			t.Errorf("Expected attempt %d to get %d, got %+v", i+1, want, a)
+		}
+	}
+}
+
+func TestSlackSendGivesUp(t *testing.T) {
+	e := &endpoint{statuses: []int{500, 500, 500}}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	h, _ := testHTTP(1)
+
+	p := models.ChannelPreference{Type: models.ChannelSlack, URL: srv.URL}
+	if err := (Slack{HTTP: h}).Send(testMessage(1), p); err == nil {
+		t.Error("Expected an error once the retries ran out")
+	}
+	if len(e.requests) != 2 {
+		t.Errorf("Expected 2 requests, got %d", len(e.requests))
+	}
+}
+
+func TestDiscordSend(t *testing.T) {
+	e := &endpoint{}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	h, _ := testHTTP(0)
+
+	p := models.ChannelPreference{Type: models.ChannelDiscord, URL: srv.URL}
+	if err := (Discord{HTTP: h}).Send(testMessage(100), p); err != nil {
+		t.Fatal(err)
+	}
+	body := map[string]string{}
+	if err := json.Unmarshal(e.requests[0].Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	content := body["content"]
+	if n := utf8.RuneCountInString(content); n != discordLimit {
+		t.Errorf("Expected the message to be cut to %d characters, got %d", discordLimit, n)
+	}
+	if !strings.HasSuffix(content, "…") || !strings.Contains(content, "**Soup**") {
+		t.Errorf("Unexpected content %q", content)
+	}
+}
+
+func TestHTTPSendWithoutURL(t *testing.T) {
+	h, log := testHTTP(0)
+	if err := (Discord{HTTP: h}).Send(testMessage(1), models.ChannelPreference{}); err == nil {
+		t.Error("Expected an error without a url")
+	}
+	if log.Len() != 0 {
+		t.Errorf("Expected no attempts, got %s", log.String())
+	}
+}
@@ -0,0 +1,113 @@
+// Package poster POSTs json bodies to HTTP endpoints for the webhooks and the
+// notification channels. Failed POSTs are retried with a backoff and every
+// attempt is written to a log as a line of json.
+package poster
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// Attempt is the record of a single try at POSTing a body. Callers embed it in
+// their own records to add what the body was.
+type Attempt struct {
+	URL      string    `json:"url"`
+	Attempt  int       `json:"attempt"`
+	Status   int       `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+	Duration string    `json:"duration"`
+}
+
+// Poster POSTs bodies retrying the ones that fail.
+type Poster struct {
+	Client  *http.Client
+	Retries int
+	// Backoff is how long we wait before the first retry, it doubles for each
+	// retry after that.
+	Backoff time.Duration
+	Log     io.Writer
+
+	logMu sync.Mutex
+}
+
+// New returns a Poster that records its attempts in log, which can be nil.
+func New(retries int, log io.Writer) *Poster {
+	if log == nil {
+		log = ioutil.Discard
+	}
+	return &Poster{
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		Retries: retries,
+		Backoff: time.Second,
+		Log:     log,
+	}
+}
+
+// Post sends the body to the url until it gets a 2xx response or runs out of
+// retries. record turns each attempt into what is written to the log.
+func (p *Poster) Post(url string, body []byte, header http.Header, record func(Attempt) interface{}) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := p.post(url, body, header, attempt, record)
+		if err == nil || attempt > p.Retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes a single attempt and records it.
+func (p *Poster) post(url string, body []byte, header http.Header, attempt int, record func(Attempt) interface{}) error {
+	start := time.Now()
+	a := Attempt{URL: url, Attempt: attempt, Time: start}
+	defer func() {
+		a.Duration = time.Since(start).String()
+		p.record(record(a))
+	}()
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		a.Error = err.Error()
+		return errors.Wrap(err, 1)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+		return errors.Wrap(err, 1)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	a.Status = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		err := errors.Errorf("%s responded with %d", url, res.StatusCode)
+		a.Error = err.Error()
+		return err
+	}
+	return nil
+}
+
+// record appends v to the log as a line of json.
+func (p *Poster) record(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	p.logMu.Lock()
+	defer p.logMu.Unlock()
+	p.Log.Write(append(b, '\n'))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/label"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// loadRecipes fills s.Recipes with the recipes of the state's site stored in
+// parse. Recipe ids are only unique within a site so the other sites are
+// skipped.
+func loadRecipes(s *State) {
+	for _, r := range getRecipesFromParse(s, 1000) {
+		if models.SiteOf(r.Site) != models.SiteOf(s.Site) {
+			continue
+		}
+		s.Recipes[r.DartmouthID] = r
+	}
+}
+
+// recipeState returns a state for the single --site with its recipes loaded,
+// it is used by the commands that don't need the rest of InitParse.
+func recipeState(c *cli.Context) State {
+	sites, err := sitesFromContext(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(sites) != 1 {
+		log.Fatal("recipe needs a single --site")
+	}
+	s := newState()
+	useSite(&s, sites[0])
+	loadRecipes(&s)
+	return s
+}
+
+// findRecipe looks up a loaded recipe by either its Dartmouth id or its parse
+// objectId.
+func findRecipe(s *State, id string) (models.ParseRecipe, error) {
+	if dartmouthID, err := strconv.Atoi(id); err == nil {
+		if r, ok := s.Recipes[dartmouthID]; ok {
+			return r, nil
+		}
+	}
+	for _, r := range s.Recipes {
+		if r.ObjectID() == id {
+			return r, nil
+		}
+	}
+	return models.ParseRecipe{}, errors.Errorf("No recipe with ID: %s", id)
+}
+
+// recipeLabel prints the Nutrition Facts panel for the recipe given as the
+// first argument.
+func recipeLabel(c *cli.Context) {
+	id := c.Args().First()
+	if id == "" {
+		log.Fatal("Usage: recipe label <id>")
+	}
+	s := recipeState(c)
+	r, err := findRecipe(&s, id)
+	if err != nil {
+		s.Log.Fatal(err)
+	}
+	facts, err := label.FromNutrients(r.Name, r.Nutrients)
+	if err != nil {
+		s.Log.Fatal(errors.Errorf("Recipe %s: %s", id, err))
+	}
+	out, err := label.Render(c.String("format"), facts)
+	if err != nil {
+		s.Log.Fatal(err)
+	}
+	fmt.Print(out)
+}
+
+// recipeHistory prints the versions recorded for the recipe given as the first
+// argument along with the fields that changed in each.
+func recipeHistory(c *cli.Context) {
+	id := c.Args().First()
+	if id == "" {
+		log.Fatal("Usage: recipe history <id>")
+	}
+	s := recipeState(c)
+	r, err := findRecipe(&s, id)
+	if err != nil {
+		s.Log.Fatal(err)
+	}
+	loadRecipeVersions(&s)
+
+	versions := s.RecipeVersions[r.DartmouthID]
+	fmt.Printf("%s (%d)\n", r.Name, r.DartmouthID)
+	if len(versions) == 0 {
+		fmt.Println("No versions recorded.")
+		return
+	}
+	for _, v := range versions {
+		fmt.Printf("Version %d  %s\n", v.Version, v.Created.Format(time.RFC822))
+		if len(v.Changes) == 0 {
+			fmt.Println("  First recorded version")
+		}
+		for _, change := range v.Changes {
+			fmt.Printf("  %s: %q -> %q\n", change.Field, change.Old, change.New)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/lib"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// reportDate is how the dates in the run reports look.
+const reportDate = "2006-01-02"
+
+// venueReport returns the report entry of the venue on the date, which is a
+// day in the timezone of the state's site.
+func venueReport(s *State, r *models.ParseScrapeRun, date time.Time, venue string) *models.VenueRun {
+	v := r.Venue(models.DayIn(date, models.LocationOf(s.Site)).Format(reportDate), venue)
+	if v.Name == "" {
+		v.Name = s.Venues[venue]
+	}
+	return v
+}
+
+// reportNotifications counts the notifications that were created and the ones
+// that were skipped because we already had them. skipped has to be worked out
+// before the notifications are saved.
+func reportNotifications(s *State, r *models.ParseScrapeRun, skipped, created []models.ParseNotification) {
+	for _, n := range skipped {
+		venueReport(s, r, n.Date(), n.Venue).NotificationsSkipped++
+	}
+	for _, n := range created {
+		venueReport(s, r, n.Date(), n.Venue).NotificationsCreated++
+	}
+}
+
+// skippedNotifications returns the notifications saveNotifications will skip.
+func skippedNotifications(s *State, ns []models.ParseNotification) []models.ParseNotification {
+	notificationsMu.RLock()
+	defer notificationsMu.RUnlock()
+	skipped := []models.ParseNotification{}
+	seen := map[string]bool{}
+	for _, n := range ns {
+		if s.Notifications[n.UUID].UUID == n.UUID || seen[n.UUID] {
+			skipped = append(skipped, n)
+			continue
+		}
+		seen[n.UUID] = true
+	}
+	return skipped
+}
+
+// reportPruned counts the notifications in ns that the prune deleted.
+func reportPruned(s *State, r *models.ParseScrapeRun, ns []models.ParseNotification, p pruneReport) {
+	deleted := map[string]bool{}
+	for _, uuid := range p.UUIDs {
+		deleted[uuid] = true
+	}
+	for _, n := range ns {
+		if deleted[n.UUID] {
+			venueReport(s, r, n.Date(), n.Venue).NotificationsDeleted++
+		}
+	}
+}
+
+// scrapedDates returns the dates, like reportDate, on which every venue in
+// the report was scraped without an error.
+func scrapedDates(r *models.ParseScrapeRun) map[string]bool {
+	scraped := map[string]bool{}
+	for _, v := range r.Venues {
+		if _, ok := scraped[v.Date]; !ok {
+			scraped[v.Date] = true
+		}
+		if v.Error != "" || v.Failed {
+			scraped[v.Date] = false
+		}
+	}
+	return scraped
+}
+
+// phase records how long the part of the run that started at start took.
+func phase(r *models.ParseScrapeRun, name string, start time.Time) {
+	r.Phases[name] = time.Since(start).Seconds()
+}
+
+// finishReport writes the report to opts.Report and stores it in parse with
+// opts.StoreReport. err is the error the run stopped with if any.
+func finishReport(s *State, r *models.ParseScrapeRun, opts scrapeOptions, err error) {
+	if err != nil {
+		r.Error = err.Error()
+	}
+	r.Drift = lib.Drift()
+	r.Finish(time.Now())
+	recordScrape(r)
+	for _, d := range r.Drift {
+		s.Log.WithFields(logrus.Fields{
+			"method":  d.Method,
+			"field":   d.Field,
+			"want":    d.Want,
+			"got":     d.Got,
+			"skipped": d.Count,
+			"example": d.Example,
+		}).Warn("Schema Drift")
+	}
+	s.Log.WithFields(logrus.Fields{
+		"venues":  len(r.Venues),
+		"recipes": r.Totals.Recipes,
+		"failed":  r.Totals.NutrientFailures,
+		"seconds": r.Seconds,
+	}).Info("Run Report")
+	if file := opts.Site.reportFile(opts.Report); file != "" {
+		if err := writeReport(file, r); err != nil {
+			s.Log.Error(err)
+		} else {
+			s.Log.WithField("file", file).Info("Wrote Report")
+		}
+	}
+	if opts.Table != nil {
+		printReport(opts.Table, r)
+	}
+	if opts.StoreReport {
+		_, status, errs := s.DB.Post(*r)
+		if errs != nil || status >= 400 {
+			s.Log.WithField("status", status).
+				Error(errors.Errorf("Unable to post ScrapeRun with run id: %s", r.RunID))
+		}
+	}
+}
+
+// writeReport writes the report as json to the file.
+func writeReport(file string, r *models.ParseScrapeRun) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		return errors.Wrap(err, 1)
+	}
+	return nil
+}
+
+// printReport writes the report as a table with a row for every venue on
+// every date followed by the totals and timings.
+func printReport(w io.Writer, r *models.ParseScrapeRun) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tVENUE\tMENUS\tMEALS\tRECIPES\tNEW\tUPDATED\tDUPLICATE\tOFFERINGS\tNUTRIENT FAILURES\tCWP ERRORS\tNOTIFIED\tSKIPPED\tDELETED\tSECONDS")
+	row := func(date, venue string, c models.RunCounts, seconds float64) {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.1f\n",
+			date, venue, c.Menus, c.Meals, c.Recipes, c.NewRecipes,
+			c.UpdatedRecipes, c.DuplicateRecipes, c.Offerings, c.NutrientFailures,
+			c.RPCErrors, c.NotificationsCreated, c.NotificationsSkipped, c.NotificationsDeleted,
+			seconds)
+	}
+	for _, v := range r.Venues {
+		venue := v.Venue
+		if v.Error != "" {
+			venue += " (failed)"
+		}
+		row(v.Date, venue, v.RunCounts, v.Seconds)
+	}
+	row("total", "", r.Totals, r.Seconds)
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nRun %s started %s\n", r.RunID,
+		r.Started.ISO.In(models.Location).Format("Jan 2 15:04:05"))
+	for _, name := range []string{"scrape", "notifications", "delivery", "prune"} {
+		if seconds, ok := r.Phases[name]; ok {
+			fmt.Fprintf(w, "  %-14s %.1fs\n", name, seconds)
+		}
+	}
+	if r.Error != "" {
+		fmt.Fprintf(w, "Stopped early: %s\n", r.Error)
+	}
+	if len(r.Drift) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nSchema drift, these items were skipped:")
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tFIELD\tWANT\tGOT\tSKIPPED\tEXAMPLE")
+	for _, d := range r.Drift {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			d.Method, d.Field, d.Want, d.Got, d.Count, d.Example)
+	}
+	tw.Flush()
+}
+
+// reportCommand prints a report written by a scrape as a table.
+func reportCommand(c *cli.Context) {
+	file := c.Args().First()
+	if file == "" {
+		file = "scrape-report.json"
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	r := models.ParseScrapeRun{}
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		log.Fatal(errors.Wrap(err, 1))
+	}
+	printReport(os.Stdout, &r)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+func TestScrapedDates(t *testing.T) {
+	r := &models.ParseScrapeRun{Venues: []models.VenueRun{
+		{Date: "2016-11-01", Venue: "DDS"},
+		{Date: "2016-11-01", Venue: "CYC"},
+		{Date: "2016-11-02", Venue: "DDS", Error: "No SID"},
+		{Date: "2016-11-02", Venue: "CYC"},
+		{Date: "2016-11-03", Venue: "DDS"},
+		{Date: "2016-11-03", Venue: "CYC", Failed: true},
+	}}
+	scraped := scrapedDates(r)
+	want := map[string]bool{"2016-11-01": true, "2016-11-02": false, "2016-11-03": false, "2016-11-04": false}
+	for date, ok := range want {
+		if scraped[date] != ok {
+			t.Errorf("%s: expected %t, got %t", date, ok, scraped[date])
+		}
+	}
+}
@@ -0,0 +1,394 @@
+// Package rules implements the subscription rule language. A rule is an
+// expression over a recipe and where it is being served eg:
+//
+//	vegan and category ~ entree and venue = CYC
+//	name ~ "ramen"
+//	protein >= 20 and not (pork or meal = breakfast)
+//
+// Comparisons look like field op value. The fields are name, category, venue,
+// meal, menu and any nutrient by its json key eg: calories, protein, fat_p.
+// The operators are = and != (case insensitive equality), ~ (contains) and
+// <, <=, > and >= which compare numbers. A dietary flag on its own, like vegan
+// or peanuts, is true if the recipe has the flag. Expressions can be combined
+// with and, or, not and parentheses.
+package rules
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// Recipe is what a rule is evaluated against.
+type Recipe struct {
+	Name     string
+	Category string
+	// Venue is matched against both the venue key and its display name
+	Venue     string
+	VenueName string
+	Meal      string
+	Menu      string
+	Nutrients models.NutrientInfoResponse
+}
+
+// Rule is a compiled rule.
+type Rule struct {
+	Text string
+	root node
+}
+
+// Compile parses the rule so it can be matched against recipes.
+func Compile(text string) (*Rule, error) {
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, errors.Errorf("Unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	return &Rule{Text: text, root: root}, nil
+}
+
+// Match is true if the recipe satisfies the rule.
+func (r *Rule) Match(recipe Recipe) bool {
+	return r.root.eval(&recipe)
+}
+
+// The fields of the nutrient result by json key, built once from the struct
+// tags so that every nutrient and flag can be used in a rule.
+var (
+	nutrientIndex = map[string]int{}
+	flagIndex     = map[string]int{}
+)
+
+func init() {
+	t := reflect.TypeOf(models.NutrientInfoResponse{}.Result)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.ToLower(strings.Split(t.Field(i).Tag.Get("json"), ",")[0])
+		switch t.Field(i).Type.Kind() {
+		case reflect.Bool:
+			flagIndex[name] = i
+		case reflect.String, reflect.Float64:
+			nutrientIndex[name] = i
+		}
+	}
+	// success isn't something anybody wants to subscribe to
+	delete(flagIndex, "success")
+}
+
+// operators are the comparison operators a rule can use.
+var operators = []string{"=", "!=", "~", "<", "<=", ">", ">="}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokOp
+	tokOpen
+	tokClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex splits the rule into words, quoted strings, operators and parentheses.
+func lex(text string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokOpen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokClose, ")", i})
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, errors.Errorf("Unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{tokString, string(runes[i+1 : end]), i})
+			i = end + 1
+		case strings.ContainsRune("=!~<>", r):
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, errors.Errorf("Unexpected ! at position %d", i)
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) &&
+				!strings.ContainsRune("()\"'=!~<>", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokWord, string(runes[start:i]), start})
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: -1, text: "end of rule", pos: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+// keyword is true and consumes the token if it is the given keyword.
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.keyword("not") {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokOpen:
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokClose {
+			return nil, errors.Errorf("Expected ) at position %d", p.peek().pos)
+		}
+		p.pos++
+		return n, nil
+	case tokWord:
+		p.pos++
+		field := strings.ToLower(t.text)
+		if p.peek().kind != tokOp {
+			index, ok := flagIndex[field]
+			if !ok {
+				return nil, errors.Errorf("Unknown flag %q at position %d", t.text, t.pos)
+			}
+			return flagNode{index}, nil
+		}
+		op := p.peek()
+		p.pos++
+		value := p.peek()
+		if value.kind != tokWord && value.kind != tokString {
+			return nil, errors.Errorf("Expected a value after %s at position %d", op.text, op.pos)
+		}
+		p.pos++
+		return newComparison(t, op, value.text)
+	}
+	return nil, errors.Errorf("Unexpected %q at position %d", t.text, t.pos)
+}
+
+// newComparison checks that the field and operator exist and that numeric
+// operators are given numbers.
+func newComparison(field, operator token, value string) (node, error) {
+	op := operator.text
+	known := false
+	for _, o := range operators {
+		known = known || o == op
+	}
+	if !known {
+		return nil, errors.Errorf("Unknown operator %s at position %d, use one of %s",
+			op, operator.pos, strings.Join(operators, " "))
+	}
+	name := strings.ToLower(field.text)
+	c := comparison{field: name, op: op, value: strings.ToLower(value), nutrient: -1}
+	switch name {
+	case "name", "category", "venue", "meal", "menu":
+	default:
+		index, ok := nutrientIndex[name]
+		if !ok {
+			return nil, errors.Errorf("Unknown field %q at position %d", field.text, field.pos)
+		}
+		c.nutrient = index
+	}
+
+	switch op {
+	case "<", "<=", ">", ">=":
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.Errorf("%s needs a number but got %q", op, value)
+		}
+		c.number = number
+	}
+	return c, nil
+}
+
+type node interface {
+	eval(r *Recipe) bool
+}
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(r *Recipe) bool { return n.left.eval(r) || n.right.eval(r) }
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(r *Recipe) bool { return n.left.eval(r) && n.right.eval(r) }
+
+type notNode struct{ n node }
+
+func (n notNode) eval(r *Recipe) bool { return !n.n.eval(r) }
+
+type flagNode struct{ index int }
+
+func (n flagNode) eval(r *Recipe) bool {
+	return reflect.ValueOf(r.Nutrients.Result).Field(n.index).Bool()
+}
+
+type comparison struct {
+	field    string
+	op       string
+	value    string
+	number   float64
+	nutrient int
+}
+
+// leadingNumber parses the number at the start of a nutrient value like
+// "12.5g", ok is false if there isn't one.
+func leadingNumber(v string) (float64, bool) {
+	v = strings.TrimSpace(v)
+	end := 0
+	for end < len(v) && (v[end] == '.' || v[end] == '-' || (v[end] >= '0' && v[end] <= '9')) {
+		end++
+	}
+	f, err := strconv.ParseFloat(v[:end], 64)
+	return f, err == nil
+}
+
+// values returns the recipe's values for the field, venues have both a key
+// and a name.
+func (c comparison) values(r *Recipe) []string {
+	switch c.field {
+	case "name":
+		return []string{models.RemoveMetaData(r.Name)}
+	case "category":
+		return []string{r.Category}
+	case "venue":
+		return []string{r.Venue, r.VenueName}
+	case "meal":
+		return []string{r.Meal}
+	case "menu":
+		return []string{r.Menu}
+	}
+	return []string{fmt.Sprint(reflect.ValueOf(r.Nutrients.Result).Field(c.nutrient).Interface())}
+}
+
+func (c comparison) eval(r *Recipe) bool {
+	values := c.values(r)
+	switch c.op {
+	case "=":
+		for _, v := range values {
+			if strings.EqualFold(strings.TrimSpace(v), c.value) {
+				return true
+			}
+		}
+		return false
+	case "!=":
+		for _, v := range values {
+			if strings.EqualFold(strings.TrimSpace(v), c.value) {
+				return false
+			}
+		}
+		return true
+	case "~":
+		for _, v := range values {
+			if strings.Contains(strings.ToLower(v), c.value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	number, ok := leadingNumber(values[0])
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return number < c.number
+	case "<=":
+		return number <= c.number
+	case ">":
+		return number > c.number
+	case ">=":
+		return number >= c.number
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+// ramen is a vegan entree at Collis with 22g of protein.
+func ramen() Recipe {
+	r := Recipe{
+		Name:      "Veggie Ramen",
+		Category:  "Entrees",
+		Venue:     "CYC",
+		VenueName: "Collis Cafe",
+		Meal:      "Lunch",
+		Menu:      "Noodle Bar",
+	}
+	r.Nutrients.Result.Vegan = true
+	r.Nutrients.Result.Soy = true
+	r.Nutrients.Result.Protein = "22g"
+	r.Nutrients.Result.Calories = "450"
+	r.Nutrients.Result.FatP = "12"
+	return r
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		rule string
+		err  string
+	}{
+		{`name ~ "ramen`, "Unterminated string"},
+		{`name ! ramen`, "Unexpected !"},
+		{`name == ramen`, "Unknown operator =="},
+		{`name ~= ramen`, "Unknown operator ~="},
+		{`name =< ramen`, "Expected a value after ="},
+		{`colour = red`, "Unknown field"},
+		{`spicy`, "Unknown flag"},
+		{`success`, "Unknown flag"},
+		{`protein >= lots`, "needs a number"},
+		{`name =`, "Expected a value"},
+		{`(vegan or soy`, "Expected )"},
+		{`vegan soy`, "Unexpected \"soy\""},
+		{`vegan and`, "Unexpected \"end of rule\""},
+		{`)`, "Unexpected \")\""},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.rule)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.rule)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected %q in %q", test.rule, test.err, err)
+		}
+	}
+}
+
+func TestUnknownOperatorListsTheValidOnes(t *testing.T) {
+	_, err := Compile("name == ramen")
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	for _, op := range operators {
+		if !strings.Contains(err.Error(), op) {
+			t.Errorf("Expected %s in %q", op, err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		// flags
+		{`vegan`, true},
+		{`VEGAN`, true},
+		{`pork`, false},
+		{`glutenfree`, false},
+		// equality is case insensitive and venues match the key or the name
+		{`meal = lunch`, true},
+		{`meal = dinner`, false},
+		{`meal != dinner`, true},
+		{`venue = cyc`, true},
+		{`venue = "collis cafe"`, true},
+		{`venue != "collis cafe"`, false},
+		// contains
+		{`name ~ ramen`, true},
+		{`name ~ "VEGGIE RA"`, true},
+		{`venue ~ collis`, true},
+		{`category ~ dessert`, false},
+		// numbers ignore the unit
+		{`protein >= 22`, true},
+		{`protein > 22`, false},
+		{`protein < 22.5`, true},
+		{`calories <= 449`, false},
+		{`fat_p = 12`, true},
+		// nutrients we don't have never compare
+		{`sodium < 100`, false},
+		{`sodium >= 0`, false},
+		// and binds tighter than or
+		{`pork and soy or vegan`, true},
+		{`pork and (soy or vegan)`, false},
+		{`vegan or pork and fish`, true},
+		{`(vegan or pork) and fish`, false},
+		// not binds tighter than and
+		{`not pork and vegan`, true},
+		{`not (pork or vegan)`, false},
+		{`not not vegan`, true},
+		{`protein >= 20 and not (pork or meal = breakfast)`, true},
+	}
+	r := ramen()
+	for _, test := range tests {
+		rule, err := Compile(test.rule)
+		if err != nil {
+			t.Errorf("%s: %s", test.rule, err)
+			continue
+		}
+		if got := rule.Match(r); got != test.want {
+			t.Errorf("%s: expected %t, got %t", test.rule, test.want, got)
+		}
+	}
+}
+
+func TestLex(t *testing.T) {
+	tokens, err := lex(`(name~'a b')and protein>=2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []token{
+		{tokOpen, "(", 0},
+		{tokWord, "name", 1},
+		{tokOp, "~", 5},
+		{tokString, "a b", 6},
+		{tokClose, ")", 11},
+		{tokWord, "and", 12},
+		{tokWord, "protein", 16},
+		{tokOp, ">=", 23},
+		{tokWord, "2", 25},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("Expected %d tokens, got %v", len(want), tokens)
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("Token %d: expected %v, got %v", i, want[i], tokens[i])
+		}
+	}
+}
+
+func TestFlagsAreEveryBoolExceptSuccess(t *testing.T) {
+	for _, flag := range []string{"vegan", "vegetarian", "glutenfree", "peanuts", "shellfish"} {
+		if _, ok := flagIndex[flag]; !ok {
+			t.Errorf("Expected %s to be a flag", flag)
+		}
+	}
+	if _, ok := flagIndex["success"]; ok {
+		t.Error("success shouldn't be a flag")
+	}
+	if _, ok := nutrientIndex["protein"]; !ok {
+		t.Error("Expected protein to be a nutrient")
+	}
+}
@@ -0,0 +1,171 @@
+// Package schedule runs jobs on cron-style schedules for the daemon.
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// macros are the shorthands that can be used instead of the five fields.
+var macros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@nightly": "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// field is the set of values allowed for one of the fields.
+type field map[int]bool
+
+// Cron is a parsed cron expression made of the five usual fields: minute,
+// hour, day of month, month and day of week eg: "30 2 * * *" or "*/15 * * * 1-5".
+// Each field is either *, a value, a range like 1-5 or a list like 1,15 and
+// any of them can have a step like */2. Sunday is both 0 and 7.
+type Cron struct {
+	Text     string
+	minute   field
+	hour     field
+	dom      field
+	month    field
+	dow      field
+	anyDOM   bool
+	anyDOW   bool
+	location *time.Location
+}
+
+// Parse parses a cron expression evaluated in the location. Expressions that
+// never match are rejected.
+func Parse(text string, loc *time.Location) (*Cron, error) {
+	expr := strings.TrimSpace(text)
+	if m, ok := macros[expr]; ok {
+		expr = m
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, errors.Errorf("Cron expression %q should have 5 fields", text)
+	}
+	c := &Cron{Text: text, location: loc}
+	bounds := []struct {
+		f        *field
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		f, err := parseField(parts[i], b.min, b.max)
+		if err != nil {
+			return nil, errors.Errorf("Cron expression %q: %s", text, err)
+		}
+		*b.f = f
+	}
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.anyDOM = parts[2] == "*"
+	c.anyDOW = parts[4] == "*"
+	// Expressions like 30 2 31 2 * parse but would never run.
+	if c.Next(time.Now()).IsZero() {
+		return nil, errors.Errorf("Cron expression %q never matches", text)
+	}
+	return c, nil
+}
+
+// parseField parses a single field that allows values from min to max.
+func parseField(text string, min, max int) (field, error) {
+	f := field{}
+	for _, part := range strings.Split(text, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, errors.Errorf("bad step in %q", part)
+			}
+			step = s
+			part = part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, errors.Errorf("bad range %q", part)
+			}
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, errors.Errorf("bad range %q", part)
+			}
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, errors.Errorf("bad value %q", part)
+			}
+			lo, hi = v, v
+			// A value with a step like 5/15 runs from the value to the max
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, errors.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			f[v] = true
+		}
+	}
+	return f, nil
+}
+
+// matchesDay follows cron in that when both the day of month and day of week
+// are restricted a day matching either of them is enough.
+func (c *Cron) matchesDay(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.anyDOM && c.anyDOW:
+		return true
+	case c.anyDOM:
+		return dow
+	case c.anyDOW:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next returns the first time after t that matches the expression. It gives
+// the zero time if nothing matches within five years, eg: for 30 2 31 2 *.
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := c.location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,132 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFieldErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"* * * *", "should have 5 fields"},
+		{"* * * * * *", "should have 5 fields"},
+		{"60 * * * *", "out of range 0-59"},
+		{"* 24 * * *", "out of range 0-23"},
+		{"* * 0 * *", "out of range 1-31"},
+		{"* * * 13 *", "out of range 1-12"},
+		{"* * * * 8", "out of range 0-7"},
+		{"5-1 * * * *", "out of range"},
+		{"*/0 * * * *", "bad step"},
+		{"*/x * * * *", "bad step"},
+		{"a-5 * * * *", "bad range"},
+		{"1-b * * * *", "bad range"},
+		{"x * * * *", "bad value"},
+		{"30 2 31 2 *", "never matches"},
+		{"0 0 30 2 *", "never matches"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.expr, time.UTC)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected %q in %q", test.expr, test.err, err)
+		}
+	}
+}
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"5", []int{5}},
+		{"1,15", []int{1, 15}},
+		{"1-5", []int{1, 2, 3, 4, 5}},
+		{"*/15", []int{0, 15, 30, 45}},
+		{"10-20/5", []int{10, 15, 20}},
+		{"50/5", []int{50, 55}},
+		{"1,3-4,*/30", []int{0, 1, 3, 4, 30}},
+	}
+	for _, test := range tests {
+		f, err := parseField(test.text, 0, 59)
+		if err != nil {
+			t.Errorf("%s: %s", test.text, err)
+			continue
+		}
+		if len(f) != len(test.want) {
+			t.Errorf("%s: expected %v, got %v", test.text, test.want, f)
+			continue
+		}
+		for _, v := range test.want {
+			if !f[v] {
+				t.Errorf("%s: expected %d, got %v", test.text, v, f)
+			}
+		}
+	}
+}
+
+// next parses expr in UTC and returns the first time it matches after from,
+// which looks like 2006-01-02 15:04.
+func next(t *testing.T, expr, from string) string {
+	c, err := Parse(expr, time.UTC)
+	if err != nil {
+		t.Fatalf("%s: %s", expr, err)
+	}
+	start, err := time.Parse("2006-01-02 15:04", from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Next(start).Format("2006-01-02 15:04 Mon")
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		expr, from, want string
+	}{
+		// the next minute, never the same one
+		{"* * * * *", "2016-02-27 10:30", "2016-02-27 10:31 Sat"},
+		{"30 2 * * *", "2016-02-27 02:30", "2016-02-28 02:30 Sun"},
+		{"*/15 * * * *", "2016-02-27 10:31", "2016-02-27 10:45 Sat"},
+		// across the end of a month, a leap year and a year
+		{"0 0 1 * *", "2016-02-27 10:30", "2016-03-01 00:00 Tue"},
+		{"0 12 29 2 *", "2016-03-01 00:00", "2020-02-29 12:00 Sat"},
+		{"0 0 1 1 *", "2016-12-31 23:59", "2017-01-01 00:00 Sun"},
+		{"@monthly", "2016-12-15 08:00", "2017-01-01 00:00 Sun"},
+		{"0 9 31 * *", "2016-04-01 00:00", "2016-05-31 09:00 Tue"},
+		// Sunday is both 0 and 7
+		{"0 8 * * 0", "2016-02-24 00:00", "2016-02-28 08:00 Sun"},
+		{"0 8 * * 7", "2016-02-24 00:00", "2016-02-28 08:00 Sun"},
+		{"0 8 * * 5-7", "2016-02-27 09:00", "2016-02-28 08:00 Sun"},
+		// with both restricted a day matching either is enough
+		{"0 0 13 * 5", "2016-02-01 00:00", "2016-02-05 00:00 Fri"},
+		{"0 0 13 * 5", "2016-02-12 00:00", "2016-02-13 00:00 Sat"},
+		// with only one restricted the other doesn't matter
+		{"0 0 13 * *", "2016-02-01 00:00", "2016-02-13 00:00 Sat"},
+		{"0 0 * * 1-5", "2016-02-27 00:00", "2016-02-29 00:00 Mon"},
+	}
+	for _, test := range tests {
+		if got := next(t, test.expr, test.from); got != test.want {
+			t.Errorf("%s from %s: expected %s, got %s", test.expr, test.from, test.want, got)
+		}
+	}
+}
+
+func TestNextIsInTheLocation(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Parse("0 2 * * *", ny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := c.Next(time.Date(2016, 2, 27, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2016, 2, 28, 2, 0, 0, 0, ny); !got.Equal(want) {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,171 @@
+package schedule
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job is something the scheduler runs on a schedule.
+type Job struct {
+	Name string
+	Cron *Cron
+	// Jitter delays every run by a random duration up to it so that jobs on
+	// the same schedule don't all hit the server at once.
+	Jitter time.Duration
+	// Lock is shared by the jobs that must never run at the same time, like
+	// two scrapes. A job is skipped if its lock is held when it is due.
+	Lock string
+	Run  func() error
+}
+
+// Status is what we know about a job's runs.
+type Status struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Running  bool      `json:"running"`
+	LastRun  time.Time `json:"lastRun"`
+	LastEnd  time.Time `json:"lastEnd"`
+	// LastError is the error of the last run, empty if it succeeded.
+	LastError string    `json:"lastError,omitempty"`
+	NextRun   time.Time `json:"nextRun"`
+	Runs      int       `json:"runs"`
+	Failures  int       `json:"failures"`
+	// Skipped counts the runs that were skipped because the job, or another
+	// job with the same lock, was still running.
+	Skipped int `json:"skipped"`
+}
+
+// Scheduler runs jobs whenever their schedules say so.
+type Scheduler struct {
+	jobs []Job
+
+	mu      sync.Mutex
+	status  map[string]*Status
+	locks   map[string]bool
+	wg      sync.WaitGroup
+	stop    chan bool
+	stopped bool
+	rand    *rand.Rand
+}
+
+// New returns a scheduler for the jobs, call Start to start running them.
+func New(jobs []Job) *Scheduler {
+	s := &Scheduler{
+		jobs:   jobs,
+		status: map[string]*Status{},
+		locks:  map[string]bool{},
+		stop:   make(chan bool),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, j := range jobs {
+		s.status[j.Name] = &Status{Name: j.Name, Schedule: j.Cron.Text}
+	}
+	return s
+}
+
+// Start starts waiting for each of the jobs to be due.
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		go s.loop(j)
+	}
+}
+
+// Stop stops scheduling new runs and waits for the running ones to finish.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// Status returns the status of every job sorted by name.
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := []Status{}
+	for _, st := range s.status {
+		statuses = append(statuses, *st)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// jitter returns a random duration up to max.
+func (s *Scheduler) jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.rand.Int63n(int64(max)))
+}
+
+// loop waits for the job to be due and runs it until the scheduler stops.
+func (s *Scheduler) loop(j Job) {
+	for {
+		next := j.Cron.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		next = next.Add(s.jitter(j.Jitter))
+		s.mu.Lock()
+		s.status[j.Name].NextRun = next
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.trigger(j)
+	}
+}
+
+// trigger runs the job in the background unless it or a job sharing its lock
+// is still running, in which case the run is skipped.
+func (s *Scheduler) trigger(j Job) bool {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return false
+	}
+	st := s.status[j.Name]
+	if st.Running || (j.Lock != "" && s.locks[j.Lock]) {
+		st.Skipped++
+		s.mu.Unlock()
+		return false
+	}
+	st.Running = true
+	st.LastRun = time.Now()
+	if j.Lock != "" {
+		s.locks[j.Lock] = true
+	}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		err := j.Run()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		st.Running = false
+		st.LastEnd = time.Now()
+		st.Runs++
+		st.LastError = ""
+		if err != nil {
+			st.Failures++
+			st.LastError = err.Error()
+		}
+		if j.Lock != "" {
+			s.locks[j.Lock] = false
+		}
+	}()
+	return true
+}
@@ -0,0 +1,93 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testJob returns a job on an hourly schedule whose runs block until release
+// is closed.
+func testJob(t *testing.T, name, lock string, release chan bool, err error) Job {
+	c, parseErr := Parse("@hourly", time.UTC)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	return Job{
+		Name: name,
+		Cron: c,
+		Lock: lock,
+		Run: func() error {
+			<-release
+			return err
+		},
+	}
+}
+
+// status returns the status of the named job.
+func status(s *Scheduler, name string) Status {
+	for _, st := range s.Status() {
+		if st.Name == name {
+			return st
+		}
+	}
+	return Status{}
+}
+
+func TestTriggerSkipsJobsSharingALock(t *testing.T) {
+	release := make(chan bool)
+	nightly := testJob(t, "nightly", "scrape", release, nil)
+	hourly := testJob(t, "hourly", "scrape", release, errors.New("failed"))
+	prune := testJob(t, "prune", "", release, nil)
+	s := New([]Job{nightly, hourly, prune})
+
+	if !s.trigger(nightly) {
+		t.Fatal("Expected nightly to run")
+	}
+	if s.trigger(hourly) {
+		t.Error("Expected hourly to be skipped while nightly holds the lock")
+	}
+	if s.trigger(nightly) {
+		t.Error("Expected nightly to be skipped while it is still running")
+	}
+	if !s.trigger(prune) {
+		t.Error("Expected prune to run, it doesn't share the lock")
+	}
+	if st := status(s, "hourly"); st.Skipped != 1 || st.Running {
+		t.Errorf("Expected hourly to be skipped once, got %+v", st)
+	}
+	if st := status(s, "nightly"); st.Skipped != 1 || !st.Running {
+		t.Errorf("Expected nightly to be running and skipped once, got %+v", st)
+	}
+
+	close(release)
+	s.Stop()
+	if st := status(s, "nightly"); st.Running || st.Runs != 1 || st.Failures != 0 {
+		t.Errorf("Expected nightly to have run once, got %+v", st)
+	}
+	if s.trigger(hourly) {
+		t.Error("Expected nothing to run once the scheduler stopped")
+	}
+}
+
+func TestTriggerReleasesTheLock(t *testing.T) {
+	release := make(chan bool)
+	close(release)
+	nightly := testJob(t, "nightly", "scrape", release, nil)
+	hourly := testJob(t, "hourly", "scrape", release, errors.New("failed"))
+	s := New([]Job{nightly, hourly})
+
+	s.trigger(nightly)
+	deadline := time.Now().Add(5 * time.Second)
+	for status(s, "nightly").Running && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !s.trigger(hourly) {
+		t.Fatal("Expected hourly to run once nightly finished")
+	}
+	s.Stop()
+	st := status(s, "hourly")
+	if st.Runs != 1 || st.Failures != 1 || st.LastError != "failed" || st.Skipped != 0 {
+		t.Errorf("Expected hourly to have failed once, got %+v", st)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/jesusrmoreno/nutrition-scraper/label"
+	"github.com/jesusrmoreno/nutrition-scraper/lib"
+	"github.com/jesusrmoreno/nutrition-scraper/metrics"
+)
+
+// serve loads everything we have stored in parse and serves it over HTTP.
+// The data is only loaded once so the server should be restarted after a
+// scrape to pick up the new offerings. /healthz and /readyz are served for
+// probes.
+func serve(c *cli.Context) {
+	log.Info("Initializing Server")
+	s := newState()
+	InitParse(&s)
+
+	// The venue names aren't stored anywhere so we ask Dartmouth for them, if
+	// that fails the keys are used as the names instead.
+	sids, err := lib.AvailableSIDS()
+	if err != nil {
+		s.Log.Error(err)
+	}
+	s.Venues = sids
+
+	schema, err := newSchema(&s)
+	if err != nil {
+		s.Log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", graphqlHandler(schema, newSessionAuth(s.DB.Client)))
+	mux.Handle("/recipes/", labelHandler(&s))
+	mux.Handle("/metrics", metrics.Handler())
+	loadLastScrape(c.String("report"))
+	newHealthChecker(c, &s).Mount(mux)
+
+	addr := c.String("addr")
+	s.Log.WithFields(logrus.Fields{
+		"addr": addr,
+	}).Info("Listening")
+	s.Log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// writeJSON writes v as the JSON body of the response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error(err)
+	}
+}
+
+// labelHandler serves the Nutrition Facts label for a recipe at
+// /recipes/<id>/label?format=svg where id is either the Dartmouth id or the
+// parse objectId. The format defaults to svg.
+func labelHandler(s *State) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) != 3 || parts[2] != "label" {
+			http.NotFound(w, r)
+			return
+		}
+		recipe, err := findRecipe(s, parts[1])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = label.SVG
+		}
+		facts, err := label.FromNutrients(recipe.Name, recipe.Nutrients)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		out, err := label.Render(format, facts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", label.ContentType(format))
+		w.Write([]byte(out))
+	})
+}
+
+// serveMetrics serves the Prometheus metrics at /metrics on addr in the
+// background.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		log.WithFields(logrus.Fields{
+			"addr": addr,
+		}).Info("Serving Metrics")
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Error(err)
+		}
+	}()
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/lib"
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+)
+
+// site is an institution running CBORD NetNutrition. They all speak the same
+// CWP JSON-RPC so a site is just where its CWP is and what timezone its menus
+// are in.
+type site struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	// URL is the site's CWP endpoint eg: http://nutrition.dartmouth.edu:8088/cwp
+	URL string `json:"url"`
+	// Files are glob patterns of VenueInfo files the site's menus are read from
+	// instead of a CWP eg: ["menus/output_*.json"]
+	Files []string `json:"files"`
+	// Timezone is the IANA name of the timezone the menus are in.
+	Timezone string `json:"timezone"`
+	// Aliases rename the site's venues eg: {"DDS": "foco"}, the alias is used
+	// as the venue key of everything we store and output.
+	Aliases map[string]string `json:"aliases"`
+
+	location *time.Location
+}
+
+// sitesConfig is the site registry file eg:
+//
+//	{
+//	  "sites": [
+//	    {"key": "state", "name": "State U", "url": "https://netnutrition.state.edu/cwp",
+//	     "timezone": "America/Chicago", "aliases": {"MAIN": "main-hall"}}
+//	  ]
+//	}
+type sitesConfig struct {
+	Sites []site `json:"sites"`
+}
+
+// builtinSites are always in the registry, a file can override them.
+var builtinSites = []site{
+	{
+		Key:      models.DefaultSite,
+		Name:     "Dartmouth College",
+		URL:      lib.DartmouthURL,
+		Timezone: "America/New_York",
+	},
+}
+
+// siteFlags pick the sites a scrape runs for.
+var siteFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "sites",
+		Usage: "Path to a site registry file with the CBORD NetNutrition sites that can be scraped.",
+	},
+	cli.StringFlag{
+		Name:  "site",
+		Value: models.DefaultSite,
+		Usage: "Comma separated keys of the sites to scrape, or all.",
+	},
+}
+
+// loadSites reads the registry file on top of the builtin sites, path can be
+// empty.
+func loadSites(path string) (map[string]site, error) {
+	config := sitesConfig{Sites: builtinSites}
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+		defer file.Close()
+		loaded := sitesConfig{}
+		if err := json.NewDecoder(file).Decode(&loaded); err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+		config.Sites = append(config.Sites, loaded.Sites...)
+	}
+	sites := map[string]site{}
+	for _, st := range config.Sites {
+		if st.Key == "" || (st.URL == "" && len(st.Files) == 0) {
+			return nil, errors.Errorf("Site %q needs a key and a url or files", st.Name)
+		}
+		loc, err := time.LoadLocation(st.Timezone)
+		if err != nil {
+			return nil, errors.Errorf("Site %s has an unknown timezone %q", st.Key, st.Timezone)
+		}
+		st.location = loc
+		if st.Key == models.DefaultSite {
+			// Everything that works in campus time should agree with the site.
+			st.location = models.Location
+		}
+		// The dates of the site's notifications and digests are days in its
+		// timezone.
+		models.SetSiteLocation(st.Key, st.location)
+		sites[st.Key] = st
+	}
+	return sites, nil
+}
+
+// sitesFromContext returns the sites picked with --site from the registry.
+func sitesFromContext(c *cli.Context) ([]site, error) {
+	registry, err := loadSites(c.String("sites"))
+	if err != nil {
+		return nil, err
+	}
+	return pickSites(registry, c.String("site"))
+}
+
+// pickSites returns the sites with the comma separated keys in the order they
+// are given, all picks every site sorted by key.
+func pickSites(registry map[string]site, keys string) ([]site, error) {
+	if keys == "all" {
+		all := []string{}
+		for key := range registry {
+			all = append(all, key)
+		}
+		sort.Strings(all)
+		keys = strings.Join(all, ",")
+	}
+	picked := []site{}
+	seen := map[string]bool{}
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" || seen[key] {
+			continue
+		}
+		st, ok := registry[key]
+		if !ok {
+			return nil, errors.Errorf("Unknown site %q", key)
+		}
+		seen[key] = true
+		picked = append(picked, st)
+	}
+	if len(picked) == 0 {
+		return nil, errors.Errorf("--site needs at least one site")
+	}
+	return picked, nil
+}
+
+// client returns the CWP client of the site.
+func (st site) client() *lib.Client {
+	if st.URL == "" || st.URL == lib.DartmouthURL {
+		return lib.Default
+	}
+	return lib.NewClient(st.URL)
+}
+
+// provider returns where the site's menus come from, its files if it has any
+// and its CWP otherwise.
+func (st site) provider() (lib.MenuProvider, error) {
+	if len(st.Files) > 0 {
+		return lib.NewStatic(st.Files...)
+	}
+	return lib.NewSessions(st.client(), lib.DefaultSessionAge), nil
+}
+
+// venueKey is the key we use for the site's venue.
+func (st site) venueKey(key string) string {
+	if alias, ok := st.Aliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+// date returns midnight in the site's timezone of the day it is at t in
+// models.Location, which is how the days to scrape are given.
+func (st site) date(t time.Time) time.Time {
+	loc := st.location
+	if loc == nil {
+		loc = models.Location
+	}
+	year, month, day := t.In(models.Location).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// isDefault is true for the site records without a site belong to.
+func (st site) isDefault() bool {
+	return models.SiteOf(st.Key) == models.DefaultSite
+}
+
+// reportFile returns where the site's run report is written. The default
+// site's report is written to name, the others get their key added to it eg:
+// scrape-report-state.json
+func (st site) reportFile(name string) string {
+	if st.isDefault() || name == "" {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + st.Key + ext
+}
+
+// outputFile returns the name of the file the venue's scrape is written to.
+func (st site) outputFile(venue string) string {
+	if st.isDefault() {
+		return fmt.Sprintf("output_%s.json", venue)
+	}
+	return fmt.Sprintf("output_%s_%s.json", st.Key, venue)
+}
+
+// loadVenueNames fills s.Venues with the names of the site's venues by our
+// keys, the keys are shown instead if the site can't be asked for them.
+func loadVenueNames(s *State, st site) {
+	provider, err := st.provider()
+	if err == nil {
+		var sids map[string]string
+		if sids, err = provider.Venues(); err == nil {
+			for key, name := range sids {
+				s.Venues[st.venueKey(key)] = name
+			}
+			return
+		}
+	}
+	s.Log.Error(err)
+}
+
+// siteWhere is the parse query for the records of the state's site. The
+// default site's records are stored without a site like they always were.
+func siteWhere(s *State) string {
+	if models.SiteOf(s.Site) == models.DefaultSite {
+		return `{"$or":[{"site":{"$exists":false}},{"site":""}]}`
+	}
+	b, _ := json.Marshal(map[string]string{"site": s.Site})
+	return string(b)
+}
+
+// useSite makes the state work on the site, it has to be called before
+// InitParse so that only the site's records are loaded. The default site's
+// records are stored without a site like they always were.
+func useSite(s *State, st site) {
+	s.Site = ""
+	if !st.isDefault() {
+		s.Site = st.Key
+	}
+	s.Log = s.Log.WithField("site", st.Key)
+}
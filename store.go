@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/jesusrmoreno/nutrition-scraper/metrics"
+	"github.com/jesusrmoreno/parse"
+)
+
+// store is the parse client with every write counted in the metrics.
+type store struct {
+	*parse.Client
+}
+
+// countWrite counts a write to the class as written or as a store error.
+func countWrite(class string, status int, errs []error) bool {
+	if errs != nil || status >= 400 {
+		metrics.Errors.WithLabelValues(metrics.ErrorStore).Inc()
+		return false
+	}
+	metrics.RowsWritten.WithLabelValues(class).Inc()
+	return true
+}
+
+// Post ...
+func (st store) Post(o parse.Object) (interface{}, int, []error) {
+	obj, status, errs := st.Client.Post(o)
+	if countWrite(o.ClassName(), status, errs) && o.ClassName() == "Notification" {
+		metrics.NotificationsCreated.Inc()
+	}
+	return obj, status, errs
+}
+
+// Put ...
+func (st store) Put(v interface{}, class, id string) (interface{}, int, []error) {
+	obj, status, errs := st.Client.Put(v, class, id)
+	countWrite(class, status, errs)
+	return obj, status, errs
+}
+
+// Delete ...
+func (st store) Delete(p parse.Params, v interface{}) (int, []error) {
+	status, errs := st.Client.Delete(p, v)
+	if errs != nil || status >= 400 {
+		metrics.Errors.WithLabelValues(metrics.ErrorStore).Inc()
+	} else if p.Class == "Notification" {
+		metrics.NotificationsDeleted.Inc()
+	}
+	return status, errs
+}
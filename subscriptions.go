@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/jesusrmoreno/nutrition-scraper/models"
+	"github.com/jesusrmoreno/nutrition-scraper/rules"
+)
+
+// ruleSubscription is a user's subscription to every recipe matching a rule.
+type ruleSubscription struct {
+	User string
+	Rule *rules.Rule
+}
+
+// subscribers returns the users subscribed to the recipe either by its id or
+// through one of their rules. Each user is only returned once.
+func subscribers(s *State, r rules.Recipe, recipeID int) []string {
+	users := []string{}
+	seen := map[string]bool{}
+	for _, user := range s.Subscriptions[recipeID] {
+		if !seen[user] {
+			seen[user] = true
+			users = append(users, user)
+		}
+	}
+	for _, sub := range s.RuleSubscriptions {
+		if !seen[sub.User] && sub.Rule.Match(r) {
+			seen[sub.User] = true
+			users = append(users, sub.User)
+		}
+	}
+	return users
+}
+
+// mealMenuNames maps the venue's meal and menu ids to their names.
+func mealMenuNames(info models.VenueInfo) (meals, menus map[int]string) {
+	meals = map[int]string{}
+	for _, meal := range info.Meals {
+		meals[meal.ID] = meal.Name
+	}
+	menus = map[int]string{}
+	for _, menu := range info.Menus {
+		menus[menu.ID] = menu.Name
+	}
+	return meals, menus
+}
+
+// matchSubscriptions returns a notification for every recipe served at the
+// venue that somebody is subscribed to.
+func matchSubscriptions(s *State, info models.VenueInfo) []models.Notification {
+	meals, menus := mealMenuNames(info)
+	ns := []models.Notification{}
+	for _, recipe := range info.Recipes {
+		r := rules.Recipe{
+			Name:      recipe.Name,
+			Category:  recipe.Category,
+			Venue:     info.Key,
+			VenueName: info.Venue,
+			Meal:      meals[recipe.MealID],
+			Menu:      menus[recipe.MenuID],
+			Nutrients: *SetDietaryInfo(&recipe.Nutrients, recipe.Name),
+		}
+		users := subscribers(s, r, recipe.ID)
+		if len(users) == 0 {
+			continue
+		}
+		ns = append(ns, models.Notification{
+			RecipeID: recipe.ID,
+			Name:     models.RemoveMetaData(recipe.Name),
+			Day:      info.Date.Day(),
+			Month:    int(info.Date.Month()),
+			Year:     info.Date.Year(),
+			OnDate:   info.Date,
+			MenuName: r.Menu,
+			MealName: r.Meal,
+			Venue:    info.Key,
+			Site:     info.Site,
+			Users:    users,
+		})
+	}
+	return ns
+}
@@ -0,0 +1,192 @@
+// Package webhook delivers the events published during a scrape to the HTTP
+// endpoints that asked for them. Every payload is signed with the endpoint's
+// secret, failed deliveries are retried and every attempt is written to the
+// delivery log.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jesusrmoreno/nutrition-scraper/events"
+	"github.com/jesusrmoreno/nutrition-scraper/poster"
+)
+
+// The headers sent along with every payload.
+const (
+	EventHeader     = "X-Scraper-Event"
+	DeliveryHeader  = "X-Scraper-Delivery"
+	SignatureHeader = "X-Scraper-Signature"
+)
+
+// Endpoint is a URL that wants to hear about events.
+type Endpoint struct {
+	URL string `json:"url"`
+	// Secret is used to sign the payloads so the endpoint can check they came
+	// from us.
+	Secret string `json:"secret"`
+	// Events are the event types the endpoint wants, it gets every event if
+	// this is empty.
+	Events []string `json:"events"`
+}
+
+// Config is the webhook configuration file eg:
+//
+//	{
+//	  "endpoints": [{
+//	    "url": "https://example.com/hooks/menus",
+//	    "secret": "s3cret",
+//	    "events": ["offering.created", "offering.updated"]
+//	  }],
+//	  "retries": 3,
+//	  "deliveryLog": "webhook-deliveries.log"
+//	}
+type Config struct {
+	Endpoints []Endpoint `json:"endpoints"`
+	// Retries is how many times a failed delivery is retried
+	Retries int `json:"retries"`
+	// DeliveryLog is the file every delivery attempt is appended to
+	DeliveryLog string `json:"deliveryLog"`
+}
+
+// LoadConfig reads the configuration from a json file.
+func LoadConfig(path string) (Config, error) {
+	c := Config{
+		Retries:     3,
+		DeliveryLog: "webhook-deliveries.log",
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return c, errors.Wrap(err, 1)
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&c); err != nil {
+		return c, errors.Wrap(err, 1)
+	}
+	return c, nil
+}
+
+// Payload is the body POSTed to the endpoints.
+type Payload struct {
+	ID string `json:"id"`
+	events.Event
+}
+
+// Delivery is the record of a single attempt to deliver a payload.
+type Delivery struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	poster.Attempt
+}
+
+// Sign returns the signature of the body sent in the SignatureHeader. It is the
+// hex encoded HMAC-SHA256 of the body using the endpoint's secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers events to the configured endpoints in the background.
+type Dispatcher struct {
+	Endpoints []Endpoint
+	Poster    *poster.Poster
+
+	wg       sync.WaitGroup
+	throttle chan bool
+}
+
+// NewDispatcher creates a dispatcher from the configuration, the delivery log
+// is opened for appending.
+func NewDispatcher(c Config) (*Dispatcher, error) {
+	var w io.Writer = ioutil.Discard
+	if c.DeliveryLog != "" {
+		file, err := os.OpenFile(c.DeliveryLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+		w = file
+	}
+	return &Dispatcher{
+		Endpoints: c.Endpoints,
+		Poster:    poster.New(c.Retries, w),
+		throttle:  make(chan bool, 10),
+	}, nil
+}
+
+// wants is true if the endpoint asked for the event type.
+func (e Endpoint) wants(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// newID returns a random id for a delivery.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// Handle queues the event for delivery to every endpoint that wants it. It is
+// meant to be subscribed to an events.Bus.
+func (d *Dispatcher) Handle(e events.Event) {
+	for _, endpoint := range d.Endpoints {
+		if !endpoint.wants(e.Type) {
+			continue
+		}
+		payload := Payload{ID: newID(), Event: e}
+		d.wg.Add(1)
+		go func(endpoint Endpoint) {
+			defer d.wg.Done()
+			// Only allow so many deliveries to run at a time
+			d.throttle <- true
+			defer func() { <-d.throttle }()
+			d.deliver(endpoint, payload)
+		}(endpoint)
+	}
+}
+
+// Close waits for the queued deliveries to finish, including their retries.
+func (d *Dispatcher) Close() {
+	d.wg.Wait()
+	if c, ok := d.Poster.Log.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// deliver POSTs the payload to the endpoint retrying with a backoff until it
+// gets a 2xx response or runs out of retries.
+func (d *Dispatcher) deliver(e Endpoint, p Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+	header := http.Header{}
+	header.Set(EventHeader, p.Type)
+	header.Set(DeliveryHeader, p.ID)
+	if e.Secret != "" {
+		header.Set(SignatureHeader, Sign(e.Secret, body))
+	}
+	return d.Poster.Post(e.URL, body, header, func(a poster.Attempt) interface{} {
+		return Delivery{ID: p.ID, Event: p.Type, Attempt: a}
+	})
+}